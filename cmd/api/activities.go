@@ -8,26 +8,144 @@ import (
 	"godvanced.forstes.github.com/internal/validator"
 )
 
+// responseInput is the answer a user picked for a question. The points are
+// looked up on the server.
+type responseInput struct {
+	QuestionID int `json:"question_id"`
+	AnswerID   int `json:"answer_id"`
+}
+
+// resolveResponses looks up the answers of the submitted responses and checks
+// that each one belongs to its question. Problems are added to v.
+func (app *application) resolveResponses(v *validator.Validator, input []responseInput) ([]*data.Response, error) {
+	if input == nil {
+		v.AddError("responses", "must be provided")
+		return nil, nil
+	}
+
+	responses := make([]*data.Response, len(input))
+	answerIDs := make([]int, len(input))
+
+	for i := range input {
+		responses[i] = &data.Response{QuestionID: input[i].QuestionID, AnswerID: input[i].AnswerID}
+		answerIDs[i] = input[i].AnswerID
+	}
+
+	answers, err := app.models.Answers.GetAnswersByID(answerIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	data.ValidateResponses(v, responses, answers)
+
+	questionIDs := make([]int, len(responses))
+	for i := range responses {
+		questionIDs[i] = responses[i].QuestionID
+	}
+
+	questions, err := app.models.Questions.GetQuestionsByID(questionIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, response := range responses {
+		if question, ok := questions[response.QuestionID]; ok {
+			response.Weight = question.Weight
+			response.MaxPoints = question.MaxPoints
+			response.Dimension = question.Dimension
+		}
+	}
+	return responses, nil
+}
+
 func (app *application) createActivityHandler(w http.ResponseWriter, r *http.Request) {
-	cookie, err := r.Cookie("auth_token")
+	user := app.contextGetUser(r)
+
+	var input struct {
+		Name      string          `json:"name"`
+		Responses []responseInput `json:"responses"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	responses, err := app.resolveResponses(v, input.Responses)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	claims, err := app.extractClaims(cookie)
+	activity := &data.Activity{
+		Name: input.Name,
+	}
+	activity.UserID = user.ID
+	activity.SetResponses(responses)
+
+	if data.ValidateActivity(v, activity); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.EvaluateActivity(activity)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	userID := int64(claims["user"].(float64))
+	err = app.models.Activities.InsertActivity(activity)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"activity": activity}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) updateActivityHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	activity, err := app.models.Activities.GetActivity(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Users can only edit their own activities, unless they may edit everyone's
+	if activity.UserID != user.ID {
+		ok, err := app.userHasPermission(user, "activities:write-all")
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		if !ok {
+			app.notPermittedResponse(w, r)
+			return
+		}
+	}
 
 	var input struct {
-		Name         string  `json:"name"`
-		AnswerPoints []int16 `json:"answer_points"`
-		AnswersSum   int16   `json:"answers_sum"`
-		Status       int16   `json:"status"`
+		Name      *string         `json:"name"`
+		Responses []responseInput `json:"responses"`
 	}
 
 	err = app.readJSON(w, r, &input)
@@ -36,54 +154,197 @@ func (app *application) createActivityHandler(w http.ResponseWriter, r *http.Req
 		return
 	}
 
-	activity := &data.Activity{
-		Name:         input.Name,
-		AnswerPoints: input.AnswerPoints,
-		AnswersSum:   input.AnswersSum,
-		Status:       input.Status,
-	}
-	activity.UserID = userID
-	if activity.AnswerPoints == nil {
-		activity.AnswerPoints = make([]int16, 0)
+	activity.ID = id
+
+	if input.Name != nil {
+		activity.Name = *input.Name
 	}
 
 	v := validator.New()
 
+	if input.Responses != nil {
+		responses, err := app.resolveResponses(v, input.Responses)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		activity.SetResponses(responses)
+	}
+
 	if data.ValidateActivity(v, activity); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
-	err = app.models.Activities.InsertActivity(activity)
+	// New answers are scored again, which replaces an overridden status.
+	if input.Responses != nil {
+		err = app.EvaluateActivity(activity)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	err = app.models.Activities.UpdateActivity(activity)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusCreated, envelope{"activity": activity}, nil)
+	err = app.writeJSON(w, http.StatusOK, envelope{"activity": activity}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
 
-func (app *application) updateActivityHandler(w http.ResponseWriter, r *http.Request) {
-	// Getting userID and Role
-	cookie, err := r.Cookie("auth_token")
+// explainActivityHandler breaks down how the activity's status came about,
+// with the scorer that set it and the points and weights the answers had at
+// the time. If the status was overridden since, the override is included.
+func (app *application) explainActivityHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	activity, err := app.models.Activities.GetActivity(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	activity.ID = id
+
+	// Users can only see how their own activities were scored, unless they may
+	// read everyone's
+	if activity.UserID != user.ID {
+		ok, err := app.userHasPermission(user, "activities:read-all")
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		if !ok {
+			app.notPermittedResponse(w, r)
+			return
+		}
+	}
+
+	responses, err := app.models.Activities.GetResponses(id)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Activities stored before responses were recorded are explained by
+	// their answer points.
+	if !activity.LegacyAnswerPoints {
+		activity.Responses = responses
+	}
+
+	scored, err := scoringResponses(activity)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	scorer, err := activityScorer(activity)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	explanation := scorer.Explain(scored)
+
+	// Scoring the answers again clears the override, so one that's still
+	// referred to set the current status.
+	var override *data.StatusOverride
+
+	if activity.StatusOverrideID != nil {
+		override, err = app.models.Activities.GetStatusOverride(*activity.StatusOverrideID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	env := envelope{
+		"activity":          activity,
+		"explanation":       explanation,
+		"status_overridden": override != nil,
+		"status_override":   override,
+	}
+
+	err = app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// simulateActivityHandler scores a set of answers the way a new activity
+// would be scored, without saving anything.
+func (app *application) simulateActivityHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Responses []responseInput `json:"responses"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	responses, err := app.resolveResponses(v, input.Responses)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	activity := &data.Activity{}
+	activity.SetResponses(responses)
+
+	err = app.EvaluateActivity(activity)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	claims, err := app.extractClaims(cookie)
+	scored, err := scoringResponses(activity)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	userID := int64(claims["user"].(float64))
-	roleInt := int8(claims["role"].(float64))
+	env := envelope{
+		"explanation":      app.scorer.Explain(scored),
+		"dimension_scores": activity.DimensionScores,
+		"region":           activity.Region,
+	}
+
+	err = app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// overrideActivityStatusHandler sets an activity's status by hand. Every
+// override is recorded with its author and reason.
+func (app *application) overrideActivityStatusHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
 
-	// Getting Activity
 	id, err := app.readIDParam(r)
 	if err != nil {
 		app.notFoundResponse(w, r)
@@ -100,17 +361,11 @@ func (app *application) updateActivityHandler(w http.ResponseWriter, r *http.Req
 		}
 		return
 	}
-
-	// User can't edit not his own activities, admin can edit anyway
-	if activity.UserID != userID && roleInt != data.AdminRole {
-		app.forbiddenResponse(w, r, err)
-		return
-	}
+	activity.ID = id
 
 	var input struct {
-		Name         *string `json:"name"`
-		AnswerPoints []int16 `json:"answer_points"`
-		Status       *int16  `json:"status"`
+		Status *int16 `json:"status"`
+		Reason string `json:"reason"`
 	}
 
 	err = app.readJSON(w, r, &input)
@@ -119,54 +374,76 @@ func (app *application) updateActivityHandler(w http.ResponseWriter, r *http.Req
 		return
 	}
 
-	activity.ID = id
+	v := validator.New()
 
-	if input.Name != nil {
-		activity.Name = *input.Name
-	}
-	if input.AnswerPoints != nil {
-		activity.AnswerPoints = input.AnswerPoints
-		app.EvaluateActivity(activity)
+	if v.Check(input.Status != nil, "status", "must be provided"); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
 	}
-	if input.Status != nil {
-		activity.Status = *input.Status
+
+	override := &data.StatusOverride{
+		UserID:    &user.ID,
+		NewStatus: *input.Status,
+		Reason:    input.Reason,
 	}
 
-	v := validator.New()
-	if data.ValidateActivity(v, activity); !v.Valid() {
+	if data.ValidateStatusOverride(v, override); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
-	err = app.models.Activities.UpdateActivity(activity)
+	err = app.models.Activities.OverrideStatus(activity, override)
 	if err != nil {
-		app.serverErrorResponse(w, r, err)
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"activity": activity}, nil)
+	err = app.writeJSON(w, http.StatusOK, envelope{"activity": activity, "override": override}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
 
-func (app *application) listActivitiesHandler(w http.ResponseWriter, r *http.Request) {
-	cookie, err := r.Cookie("auth_token")
+func (app *application) listActivityStatusOverridesHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
 	if err != nil {
-		app.serverErrorResponse(w, r, err)
+		app.notFoundResponse(w, r)
 		return
 	}
 
-	claims, err := app.extractClaims(cookie)
+	_, err = app.models.Activities.GetActivity(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	overrides, err := app.models.Activities.GetStatusOverrides(id)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	userID := int64(claims["user"].(float64))
+	err = app.writeJSON(w, http.StatusOK, envelope{"overrides": overrides}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listActivitiesHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
 
 	v := validator.New()
-	app.getUserActivities(userID, v, w, r)
+	app.getUserActivities(user.ID, v, w, r)
 }
 
 func (app *application) listUserActivitiesHandler(w http.ResponseWriter, r *http.Request) {
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"godvanced.forstes.github.com/internal/data"
+	"godvanced.forstes.github.com/internal/validator"
+)
+
+func (app *application) listUsersHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Search string
+		data.Filters
+	}
+
+	v := validator.New()
+	qs := r.URL.Query()
+
+	input.Search = app.readString(qs, "search", "")
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = app.readString(qs, "sort", "id")
+	input.Filters.SortSafelist = []string{"id", "-id", "email", "-email", "name", "-name", "created_at", "-created_at"}
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	users, metadata, err := app.models.Users.GetAllUsers(input.Search, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	profiles := make([]*data.UserProfile, len(users))
+	for i, user := range users {
+		profiles[i] = user.Profile()
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"users": profiles, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showUserHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	user, err := app.models.Users.GetUserByID(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	permissions, err := app.models.Permissions.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"user": user.Profile(), "permissions": permissions}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) updateUserHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	user, err := app.models.Users.GetUserByID(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	var input struct {
+		Role      *int  `json:"role"`
+		Activated *bool `json:"activated"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	manager := app.contextGetUser(r)
+
+	if !app.canManage(manager, user) {
+		app.notPermittedResponse(w, r)
+		return
+	}
+
+	// Only admins assign roles, otherwise users:manage would be enough to
+	// promote anyone to admin.
+	if input.Role != nil && *input.Role != user.Role && !app.isAdmin(manager) {
+		app.notPermittedResponse(w, r)
+		return
+	}
+
+	v := validator.New()
+
+	// Admins can't demote themselves, so there is always someone left to
+	// manage users.
+	if input.Role != nil && *input.Role != user.Role {
+		v.Check(user.ID != manager.ID, "role", "you can't change your own role")
+		data.ValidateRole(v, *input.Role)
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Changing the role or deactivating the account ends the user's sessions.
+	// Access tokens carrying the old role are rejected by authenticate.
+	revokeSessions := false
+
+	if input.Role != nil && *input.Role != user.Role {
+		user.Role = *input.Role
+		revokeSessions = true
+	}
+	if input.Activated != nil {
+		revokeSessions = revokeSessions || (user.Activated && !*input.Activated)
+		user.Activated = *input.Activated
+	}
+
+	err = app.models.Users.UpdateUser(user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if revokeSessions {
+		err = app.models.Tokens.DeleteAllForUser(data.ScopeRefresh, user.ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"user": user.Profile()}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteUserHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	manager := app.contextGetUser(r)
+
+	if id == manager.ID {
+		app.badRequestResponse(w, r, errors.New("you can't delete your own account"))
+		return
+	}
+
+	user, err := app.models.Users.GetUserByID(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if !app.canManage(manager, user) {
+		app.notPermittedResponse(w, r)
+		return
+	}
+
+	err = app.models.Users.DeleteUser(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "user successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) updateUserPermissionsHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	user, err := app.models.Users.GetUserByID(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	var input struct {
+		Permissions []string `json:"permissions"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	manager := app.contextGetUser(r)
+
+	if !app.canManage(manager, user) {
+		app.notPermittedResponse(w, r)
+		return
+	}
+
+	v := validator.New()
+
+	v.Check(user.ID != manager.ID, "permissions", "you can't change your own permissions")
+
+	if data.ValidatePermissions(v, input.Permissions); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Managers who aren't admins can only grant and take away permissions
+	// they hold themselves, so two of them can't raise each other further.
+	if !app.isAdmin(manager) {
+		held, err := app.models.Permissions.GetAllForUser(manager.ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		current, err := app.models.Permissions.GetAllForUser(user.ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		for _, code := range data.PermissionCodes {
+			changed := data.Permissions(input.Permissions).Include(code) != current.Include(code)
+			if changed && !held.Include(code) {
+				app.notPermittedResponse(w, r)
+				return
+			}
+		}
+	}
+
+	err = app.models.Permissions.SetForUser(user.ID, input.Permissions)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	permissions, err := app.models.Permissions.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"user": user.Profile(), "permissions": permissions}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) isAdmin(user *data.User) bool {
+	return user.Role == data.AdminRole && !app.adminMissingTwoFactor(user)
+}
+
+// canManage reports whether manager may change or delete user. Holding
+// users:manage is enough for everyone but admins, who only other admins can
+// touch.
+func (app *application) canManage(manager, user *data.User) bool {
+	return user.Role != data.AdminRole || app.isAdmin(manager)
+}
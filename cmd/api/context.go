@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"net/http"
+
+	"godvanced.forstes.github.com/internal/data"
+)
+
+type contextKey string
+
+const (
+	userContextKey   = contextKey("user")
+	apiKeyContextKey = contextKey("apiKey")
+
+	invalidCredentialsContextKey = contextKey("invalidCredentials")
+)
+
+func (app *application) contextSetUser(r *http.Request, user *data.User) *http.Request {
+	ctx := context.WithValue(r.Context(), userContextKey, user)
+	return r.WithContext(ctx)
+}
+
+// contextGetUser is only called from handlers behind the authenticate
+// middleware, so a missing value is a programming error.
+func (app *application) contextGetUser(r *http.Request) *data.User {
+	user, ok := r.Context().Value(userContextKey).(*data.User)
+	if !ok {
+		panic("missing user value in request context")
+	}
+	return user
+}
+
+func (app *application) contextSetAPIKey(r *http.Request, key *data.APIKey) *http.Request {
+	ctx := context.WithValue(r.Context(), apiKeyContextKey, key)
+	return r.WithContext(ctx)
+}
+
+// contextGetAPIKey returns the API key the request was authenticated with, or
+// nil for requests made with a session or without credentials.
+func (app *application) contextGetAPIKey(r *http.Request) *data.APIKey {
+	key, _ := r.Context().Value(apiKeyContextKey).(*data.APIKey)
+	return key
+}
+
+// contextSetInvalidCredentials marks an anonymous request as one that came
+// with credentials the authenticate middleware didn't accept.
+func (app *application) contextSetInvalidCredentials(r *http.Request) *http.Request {
+	ctx := context.WithValue(r.Context(), invalidCredentialsContextKey, true)
+	return r.WithContext(ctx)
+}
+
+func (app *application) contextHasInvalidCredentials(r *http.Request) bool {
+	invalid, _ := r.Context().Value(invalidCredentialsContextKey).(bool)
+	return invalid
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"net/http"
+	"time"
+)
+
+// newCookie returns an HttpOnly cookie with the Secure and SameSite
+// attributes configured for the environment. All cookies of the API are made
+// here, so their attributes are set in one place.
+func (app *application) newCookie(name, value, path string) *http.Cookie {
+	return &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     path,
+		HttpOnly: true,
+		Secure:   app.config.cookies.secure,
+		SameSite: app.config.cookies.sameSite,
+	}
+}
+
+func (app *application) setCookie(w http.ResponseWriter, name, value, path string, expires time.Time) {
+	cookie := app.newCookie(name, value, path)
+	cookie.Expires = expires
+	http.SetCookie(w, cookie)
+}
+
+func (app *application) clearCookie(w http.ResponseWriter, name, path string) {
+	cookie := app.newCookie(name, "", path)
+	cookie.MaxAge = -1
+	http.SetCookie(w, cookie)
+}
@@ -1,21 +1,80 @@
 package main
 
-import "godvanced.forstes.github.com/internal/data"
+import (
+	"errors"
+	"fmt"
 
-func (app *application) EvaluateActivity(activity *data.Activity) {
-	maxPoints := int16(len(activity.AnswerPoints) * 3)
-	toolBound := maxPoints * 2 / 3
+	"godvanced.forstes.github.com/internal/data"
+	"godvanced.forstes.github.com/internal/scoring"
+)
 
-	for _, ans := range activity.AnswerPoints {
-		activity.AnswersSum += ans
+// EvaluateActivity computes the activity's answers sum and status from its
+// responses with the configured scorer, and records which scorer it was. It
+// is the only way a status is set, apart from an explicit override. The
+// dimension scores and the region don't depend on the scorer.
+func (app *application) EvaluateActivity(activity *data.Activity) error {
+	responses, err := scoringResponses(activity)
+	if err != nil {
+		return err
 	}
+	result := app.scorer.Score(responses)
+
+	activity.AnswersSum = result.AnswersSum
+	activity.Status = result.Status
+	activity.DimensionScores = scoring.DimensionScores(responses)
+	activity.Region = scoring.Region(activity.DimensionScores)
+	activity.Scorer = app.scorer.Name()
+	activity.ScorerVersion = app.scorer.Version()
+	activity.StatusOverrideID = nil
+	return nil
+}
+
+// activityScorer returns the scorer that produced the activity's result. A
+// different scorer could explain a status the activity never had, so a scorer
+// that is no longer registered is an error.
+func activityScorer(activity *data.Activity) (scoring.Scorer, error) {
+	scorer, ok := scoring.Get(activity.Scorer, activity.ScorerVersion)
+	if !ok {
+		return nil, fmt.Errorf("activity %d was scored by unknown scorer %s v%d", activity.ID, activity.Scorer, activity.ScorerVersion)
+	}
+	return scorer, nil
+}
+
+// legacyMaxPoints is what every answer was worth at most when activities only
+// stored their answer points.
+const legacyMaxPoints = 3
+
+// errNoResponses is returned for an activity whose responses weren't loaded,
+// and which isn't old enough to be scored by its answer points alone.
+var errNoResponses = errors.New("activity responses not loaded")
+
+// scoringResponses returns the activity's responses for a scorer. Activities
+// stored before responses were recorded only have their answer points, which
+// count with the default weight and the old maximum.
+func scoringResponses(activity *data.Activity) ([]scoring.Response, error) {
+	var responses []scoring.Response
 
 	switch {
-	case activity.AnswersSum == maxPoints:
-		activity.Status = data.Ikigai
-	case activity.AnswersSum > toolBound:
-		activity.Status = data.Tool
+	case activity.Responses != nil:
+		for _, response := range activity.Responses {
+			responses = append(responses, scoring.Response{
+				QuestionID: response.QuestionID,
+				Points:     response.Points,
+				MaxPoints:  response.MaxPoints,
+				Weight:     response.Weight,
+				Dimension:  response.Dimension,
+			})
+		}
+	case activity.LegacyAnswerPoints:
+		for _, points := range activity.AnswerPoints {
+			responses = append(responses, scoring.Response{
+				Points:    points,
+				MaxPoints: legacyMaxPoints,
+				Weight:    1,
+			})
+		}
 	default:
-		activity.Status = data.Trash
+		return nil, errNoResponses
 	}
+	return responses, nil
 }
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"godvanced.forstes.github.com/internal/scoring"
+)
+
+func (app *application) startJobs(ctx context.Context) {
+	app.runPeriodically(ctx, "purge deleted users", time.Hour, app.purgeDeletedUsers)
+	app.runPeriodically(ctx, "delete expired tokens", 15*time.Minute, app.deleteExpiredTokens)
+	app.runOnce(ctx, "score activity dimensions", app.scoreActivityDimensions)
+}
+
+// runOnce calls fn in the background, for jobs that catch up on data stored
+// before a migration. fn should stop early when ctx is cancelled.
+func (app *application) runOnce(ctx context.Context, name string, fn func(context.Context) error) {
+	app.background(func() {
+		err := fn(ctx)
+		if err != nil {
+			app.logger.PrintError(err, map[string]string{
+				"job": name,
+			})
+		}
+	})
+}
+
+// runPeriodically calls fn every interval until ctx is cancelled. It goes
+// through app.background, so shutdown waits for a run that is in progress.
+func (app *application) runPeriodically(ctx context.Context, name string, interval time.Duration, fn func() error) {
+	app.background(func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				err := fn()
+				if err != nil {
+					app.logger.PrintError(err, map[string]string{
+						"job": name,
+					})
+				}
+			}
+		}
+	})
+}
+
+func (app *application) purgeDeletedUsers() error {
+	count, err := app.models.Users.PurgeDeleted()
+	if err != nil {
+		return err
+	}
+
+	if count > 0 {
+		app.logger.PrintInfo("purged deleted users", map[string]string{
+			"count": strconv.FormatInt(count, 10),
+		})
+	}
+	return nil
+}
+
+func (app *application) deleteExpiredTokens() error {
+	count, acquired, err := app.models.Tokens.DeleteExpired(1000)
+	if err != nil {
+		return err
+	}
+
+	if !acquired {
+		app.logger.PrintInfo("expired tokens are being deleted by another instance", nil)
+		return nil
+	}
+
+	app.logger.PrintInfo("deleted expired tokens", map[string]string{
+		"count": strconv.FormatInt(count, 10),
+	})
+	return nil
+}
+
+// scoreActivityDimensions computes the dimension scores and region of the
+// activities stored before there were any, from their recorded responses, so
+// that they show up when filtering by region. Activities that only have their
+// answer points end up in no region.
+func (app *application) scoreActivityDimensions(ctx context.Context) error {
+	var count int
+
+	for ctx.Err() == nil {
+		activities, err := app.models.Activities.GetWithoutDimensionScores(100)
+		if err != nil {
+			return err
+		}
+		if len(activities) == 0 {
+			break
+		}
+
+		for _, activity := range activities {
+			if !activity.LegacyAnswerPoints {
+				activity.Responses, err = app.models.Activities.GetResponses(activity.ID)
+				if err != nil {
+					return err
+				}
+			}
+
+			responses, err := scoringResponses(activity)
+			if err != nil {
+				return err
+			}
+
+			activity.DimensionScores = scoring.DimensionScores(responses)
+			activity.Region = scoring.Region(activity.DimensionScores)
+
+			err = app.models.Activities.SetDimensionScores(activity)
+			if err != nil {
+				return err
+			}
+			count++
+		}
+	}
+
+	if count > 0 {
+		app.logger.PrintInfo("scored activity dimensions", map[string]string{
+			"count": strconv.Itoa(count),
+		})
+	}
+	return nil
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/golang-jwt/jwt"
+)
+
+// jwtKey is one asymmetric key of the key set. Keys without a private part
+// only verify tokens: they belong to a retired signing key whose tokens may
+// still be in use.
+type jwtKey struct {
+	kid     string
+	method  jwt.SigningMethod
+	private crypto.PrivateKey
+	public  crypto.PublicKey
+}
+
+type jwtKeySet struct {
+	signing *jwtKey
+	keys    map[string]*jwtKey
+}
+
+// loadJWTKeySet reads every <kid>.pem file in dir. Private keys can sign and
+// verify, public keys can only verify. signingKID selects the key that signs
+// new tokens, the others stay active so tokens issued before a rotation keep
+// working until they expire.
+func loadJWTKeySet(dir, signingKID string) (*jwtKeySet, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+
+	set := &jwtKeySet{keys: make(map[string]*jwtKey)}
+
+	for _, file := range files {
+		pemBytes, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+
+		kid := strings.TrimSuffix(filepath.Base(file), ".pem")
+
+		key, err := parseJWTKey(kid, pemBytes)
+		if err != nil {
+			return nil, fmt.Errorf("jwt key %q: %w", kid, err)
+		}
+		set.keys[kid] = key
+	}
+
+	if len(set.keys) == 0 {
+		return nil, fmt.Errorf("no jwt keys found in %s", dir)
+	}
+
+	signing, ok := set.keys[signingKID]
+	if !ok {
+		return nil, fmt.Errorf("jwt signing key %q not found in %s", signingKID, dir)
+	}
+	if signing.private == nil {
+		return nil, fmt.Errorf("jwt signing key %q has no private key", signingKID)
+	}
+	set.signing = signing
+
+	return set, nil
+}
+
+func parseJWTKey(kid string, pemBytes []byte) (*jwtKey, error) {
+	block, _ := pem.Decode(pemBytes)
+	if block == nil {
+		return nil, errors.New("key must be PEM encoded")
+	}
+
+	key := &jwtKey{kid: kid}
+
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		private, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		key.private = private
+	case "PRIVATE KEY":
+		private, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		key.private = private
+	case "RSA PUBLIC KEY":
+		public, err := x509.ParsePKCS1PublicKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		key.public = public
+	case "PUBLIC KEY":
+		public, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		key.public = public
+	default:
+		return nil, fmt.Errorf("unsupported PEM block type %q", block.Type)
+	}
+
+	switch private := key.private.(type) {
+	case *rsa.PrivateKey:
+		key.public = &private.PublicKey
+	case ed25519.PrivateKey:
+		key.public = private.Public()
+	case nil:
+	default:
+		return nil, errors.New("only RSA and Ed25519 keys are supported")
+	}
+
+	switch key.public.(type) {
+	case *rsa.PublicKey:
+		key.method = jwt.SigningMethodRS256
+	case ed25519.PublicKey:
+		key.method = jwt.SigningMethodEdDSA
+	default:
+		return nil, errors.New("only RSA and Ed25519 keys are supported")
+	}
+
+	return key, nil
+}
+
+// verificationKey returns the public key for a token signed with one of the
+// asymmetric keys of the set.
+func (s *jwtKeySet) verificationKey(token *jwt.Token) (crypto.PublicKey, error) {
+	kid, _ := token.Header["kid"].(string)
+
+	key, ok := s.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	if key.method.Alg() != token.Method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+	}
+	return key.public, nil
+}
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+func (s *jwtKeySet) jwks() []jsonWebKey {
+	keys := []jsonWebKey{}
+
+	for _, key := range s.keys {
+		jwk := jsonWebKey{Kid: key.kid, Use: "sig", Alg: key.method.Alg()}
+
+		switch public := key.public.(type) {
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.Kty = "OKP"
+			jwk.Crv = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(public)
+		}
+		keys = append(keys, jwk)
+	}
+
+	sort.Slice(keys, func(i, j int) bool { return keys[i].Kid < keys[j].Kid })
+	return keys
+}
+
+func (app *application) jwksHandler(w http.ResponseWriter, r *http.Request) {
+	keys := []jsonWebKey{}
+	if app.config.jwtOptions.keySet != nil {
+		keys = app.config.jwtOptions.keySet.jwks()
+	}
+
+	headers := make(http.Header)
+	headers.Set("Cache-Control", "public, max-age=300")
+
+	err := app.writeJSON(w, http.StatusOK, envelope{"keys": keys}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
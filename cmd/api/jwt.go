@@ -1,41 +1,152 @@
 package main
 
 import (
+	"errors"
 	"fmt"
+	"net"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/golang-jwt/jwt"
 	"godvanced.forstes.github.com/internal/data"
 )
 
+// jwtOptions holds the HMAC key used until a key set is configured. When
+// both are set, tokens are signed with the key set and HMAC tokens are still
+// accepted, which lets a deployment move off the shared secret without
+// logging everyone out.
 type jwtOptions struct {
-	key     string
-	expires time.Duration
+	key            string
+	keySet         *jwtKeySet
+	expires        time.Duration
+	refreshExpires time.Duration
 }
 
 func (app *application) generateJWT(user *data.User) (string, error) {
-	token := jwt.New(jwt.SigningMethodHS256)
+	claims := jwt.MapClaims{
+		"exp":   time.Now().Add(app.config.jwtOptions.expires).Unix(),
+		"user":  user.ID,
+		"email": user.Email,
+		"role":  user.Role,
+	}
+
+	var signingKey any = []byte(app.config.jwtOptions.key)
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 
-	claims := token.Claims.(jwt.MapClaims)
-	claims["exp"] = time.Now().Add(app.config.jwtOptions.expires).Unix()
-	claims["user"] = user.ID
-	claims["email"] = user.Email
-	claims["role"] = user.Role
+	if keySet := app.config.jwtOptions.keySet; keySet != nil {
+		signingKey = keySet.signing.private
+		token = jwt.NewWithClaims(keySet.signing.method, claims)
+		token.Header["kid"] = keySet.signing.kid
+	}
 
-	tokenString, err := token.SignedString([]byte(app.config.jwtOptions.key))
+	tokenString, err := token.SignedString(signingKey)
 	if err != nil {
 		return "", err
 	}
 	return tokenString, nil
 }
 
-func (app *application) extractToken(cookie *http.Cookie) (*jwt.Token, error) {
-	token, err := jwt.Parse(cookie.Value, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+type sessionTokens struct {
+	AccessToken        string    `json:"access_token"`
+	AccessTokenExpiry  time.Time `json:"access_token_expiry"`
+	RefreshToken       string    `json:"refresh_token"`
+	RefreshTokenExpiry time.Time `json:"refresh_token_expiry"`
+}
+
+// startSession sets a short-lived access JWT and a refresh token as cookies
+// and returns them for clients that don't use cookies. previous is nil for a
+// new login and the previous refresh token when rotating.
+func (app *application) startSession(w http.ResponseWriter, r *http.Request, user *data.User, previous *data.Token) (*sessionTokens, error) {
+	token, err := app.generateJWT(user)
+	if err != nil {
+		return nil, err
+	}
+
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+
+	refreshToken, err := app.models.Tokens.NewRefresh(user.ID, app.config.jwtOptions.refreshExpires, previous, r.UserAgent(), ip)
+	if err != nil {
+		return nil, err
+	}
+
+	tokens := &sessionTokens{
+		AccessToken:        token,
+		AccessTokenExpiry:  time.Now().Add(app.config.jwtOptions.expires),
+		RefreshToken:       refreshToken.Plaintext,
+		RefreshTokenExpiry: refreshToken.Expiry,
+	}
+
+	app.setCookie(w, "auth_token", tokens.AccessToken, "/", tokens.AccessTokenExpiry)
+	app.setCookie(w, "refresh_token", tokens.RefreshToken, "/v1", tokens.RefreshTokenExpiry)
+	return tokens, nil
+}
+
+func (app *application) clearSession(w http.ResponseWriter) {
+	app.clearCookie(w, "auth_token", "/")
+	app.clearCookie(w, "refresh_token", "/v1")
+}
+
+var errMissingAuthToken = errors.New("missing authentication token")
+
+// readAuthToken returns the access JWT from an "Authorization: Bearer" header
+// or, when there is no such header, from the auth_token cookie.
+func (app *application) readAuthToken(r *http.Request) (string, error) {
+	authorizationHeader := r.Header.Get("Authorization")
+	if authorizationHeader != "" {
+		headerParts := strings.Split(authorizationHeader, " ")
+		if len(headerParts) != 2 || headerParts[0] != "Bearer" || headerParts[1] == "" {
+			return "", errors.New("malformed authorization header")
+		}
+		return headerParts[1], nil
+	}
+
+	cookie, err := r.Cookie("auth_token")
+	if err != nil {
+		return "", errMissingAuthToken
+	}
+	return cookie.Value, nil
+}
+
+// readRefreshToken returns the refresh token from the refresh_token cookie or,
+// for clients that don't keep cookies, from a {"refresh_token": "..."} body.
+// fromBody reports where the token was found.
+func (app *application) readRefreshToken(w http.ResponseWriter, r *http.Request) (token string, fromBody bool) {
+	cookie, err := r.Cookie("refresh_token")
+	if err == nil {
+		return cookie.Value, false
+	}
+
+	var input struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		return "", false
+	}
+	return input.RefreshToken, true
+}
+
+func (app *application) extractToken(tokenString string) (*jwt.Token, error) {
+	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		switch token.Method.(type) {
+		case *jwt.SigningMethodHMAC:
+			if app.config.jwtOptions.key == "" {
+				return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+			}
+			return []byte(app.config.jwtOptions.key), nil
+		case *jwt.SigningMethodRSA, *jwt.SigningMethodEd25519:
+			if app.config.jwtOptions.keySet == nil {
+				return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+			}
+			return app.config.jwtOptions.keySet.verificationKey(token)
+		default:
 			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
 		}
-		return []byte(app.config.jwtOptions.key), nil
 	})
 	if err != nil {
 		return nil, err
@@ -43,8 +154,8 @@ func (app *application) extractToken(cookie *http.Cookie) (*jwt.Token, error) {
 	return token, nil
 }
 
-func (app *application) extractClaims(cookie *http.Cookie) (jwt.MapClaims, error) {
-	token, err := app.extractToken(cookie)
+func (app *application) extractClaims(tokenString string) (jwt.MapClaims, error) {
+	token, err := app.extractToken(tokenString)
 	if err != nil {
 		return nil, err
 	}
@@ -55,61 +166,222 @@ func (app *application) extractClaims(cookie *http.Cookie) (jwt.MapClaims, error
 	return nil, err
 }
 
-func (app *application) verifyJWTMiddleware(next http.Handler) http.HandlerFunc {
+// authenticate parses the access token once and stores the caller in the
+// request context. Requests without a token get data.AnonymousUser, and so do
+// requests with an invalid one, see continueAnonymously.
+func (app *application) authenticate(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Authorization")
+
+		if authorizationHeader := r.Header.Get("Authorization"); strings.HasPrefix(authorizationHeader, "ApiKey ") {
+			app.authenticateAPIKey(w, r, strings.TrimPrefix(authorizationHeader, "ApiKey "), next)
+			return
+		}
 
-		cookie, err := r.Cookie("auth_token")
+		tokenString, err := app.readAuthToken(r)
 		if err != nil {
-			app.forbiddenResponse(w, r, err)
+			switch {
+			case errors.Is(err, errMissingAuthToken):
+				r = app.contextSetUser(r, data.AnonymousUser)
+				next.ServeHTTP(w, r)
+			default:
+				app.continueAnonymously(w, r, next)
+			}
+			return
+		}
+
+		claims, err := app.extractClaims(tokenString)
+		if err != nil || claims == nil {
+			app.continueAnonymously(w, r, next)
+			return
+		}
+
+		userID, ok := claims["user"].(float64)
+		if !ok {
+			app.continueAnonymously(w, r, next)
 			return
 		}
 
-		token, err := app.extractToken(cookie)
+		// The user is loaded on every request instead of being rebuilt from the
+		// claims, so role and activation changes apply without a new login.
+		user, err := app.models.Users.GetUserByID(int64(userID))
 		if err != nil {
-			app.forbiddenResponse(w, r, err)
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				app.continueAnonymously(w, r, next)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
 			return
 		}
 
-		if !token.Valid {
-			app.forbiddenResponse(w, r, err)
+		// A token issued before the user's role changed is rejected, so the old
+		// role in its claims can't be relied on anywhere.
+		role, ok := claims["role"].(float64)
+		if !ok || int(role) != user.Role {
+			app.continueAnonymously(w, r, next)
 			return
 		}
+
+		r = app.contextSetUser(r, user)
 		next.ServeHTTP(w, r)
 	})
 }
 
-func (app *application) JWTAdminOnlyMiddleware(next http.Handler) http.HandlerFunc {
+// continueAnonymously handles a request whose credentials are invalid,
+// expired or outdated as an anonymous one. Routes that need a user still
+// reject it in verifyJWTMiddleware and requirePermission, but logging in,
+// refreshing and logging out keep working while a stale auth_token cookie is
+// around. The cookie is cleared on the way.
+func (app *application) continueAnonymously(w http.ResponseWriter, r *http.Request, next http.Handler) {
+	if r.Header.Get("Authorization") == "" {
+		app.clearCookie(w, "auth_token", "/")
+	}
+
+	r = app.contextSetUser(r, data.AnonymousUser)
+	r = app.contextSetInvalidCredentials(r)
+	next.ServeHTTP(w, r)
+}
+
+// requireAuthenticationResponse answers an anonymous request to a route that
+// needs a user, telling clients whether the credentials they sent were bad.
+func (app *application) requireAuthenticationResponse(w http.ResponseWriter, r *http.Request) {
+	if app.contextHasInvalidCredentials(r) {
+		app.invalidAuthenticationTokenResponse(w, r)
+		return
+	}
+	app.authenticationRequiredResponse(w, r)
+}
+
+// authenticateAPIKey authenticates a request made with an
+// "Authorization: ApiKey <key>" header. The key is stored in the context next
+// to its owner, so that permission checks can be limited to its scopes.
+func (app *application) authenticateAPIKey(w http.ResponseWriter, r *http.Request, keyPlaintext string, next http.Handler) {
+	key, err := app.models.APIKeys.Use(keyPlaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.continueAnonymously(w, r, next)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	user, err := app.models.Users.GetUserByID(key.UserID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.continueAnonymously(w, r, next)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	r = app.contextSetUser(r, user)
+	r = app.contextSetAPIKey(r, key)
+	next.ServeHTTP(w, r)
+}
+
+// verifyJWTMiddleware requires a user who signed in. API keys are only good
+// for the routes behind requirePermission, so they are turned away here.
+func (app *application) verifyJWTMiddleware(next http.Handler) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user := app.contextGetUser(r)
 
-		cookie, err := r.Cookie("auth_token")
-		if err != nil {
-			app.forbiddenResponse(w, r, err)
+		if user.IsAnonymous() {
+			app.requireAuthenticationResponse(w, r)
 			return
 		}
 
-		token, err := app.extractToken(cookie)
-		if err != nil {
-			app.forbiddenResponse(w, r, err)
+		if app.contextGetAPIKey(r) != nil {
+			app.apiKeyNotAllowedResponse(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// userHasPermission reports whether the user was granted the permission code.
+// Admins hold every permission, unless two-factor authentication is required
+// for them and they haven't enabled it yet.
+func (app *application) userHasPermission(user *data.User, code string) (bool, error) {
+	if user.Role == data.AdminRole && !app.adminMissingTwoFactor(user) {
+		return true, nil
+	}
+
+	permissions, err := app.models.Permissions.GetAllForUser(user.ID)
+	if err != nil {
+		return false, err
+	}
+	return permissions.Include(code), nil
+}
+
+func (app *application) adminMissingTwoFactor(user *data.User) bool {
+	return app.config.twoFactor.requiredForAdmins && user.Role == data.AdminRole && !user.TOTPEnabled
+}
+
+// requirePermission accepts sessions and API keys. A request made with an API
+// key needs the permission both in the key's scopes and on its owner.
+func (app *application) requirePermission(code string, next http.Handler) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user := app.contextGetUser(r)
+
+		if user.IsAnonymous() {
+			app.requireAuthenticationResponse(w, r)
 			return
 		}
 
-		if !token.Valid {
-			app.forbiddenResponse(w, r, err)
+		if !app.checkAccountActive(w, r, user) {
 			return
 		}
 
-		claims, err := app.extractClaims(cookie)
+		ok, err := app.userHasPermission(user, code)
 		if err != nil {
-			app.badRequestResponse(w, r, err)
+			app.serverErrorResponse(w, r, err)
 			return
 		}
 
-		roleInt := int8(claims["role"].(float64))
+		if key := app.contextGetAPIKey(r); key != nil && !key.Scopes.Include(code) {
+			app.notPermittedResponse(w, r)
+			return
+		}
 
-		if roleInt != data.AdminRole {
-			app.forbiddenResponse(w, r, err)
+		if !ok {
+			switch {
+			case app.adminMissingTwoFactor(user):
+				app.twoFactorRequiredResponse(w, r)
+			default:
+				app.notPermittedResponse(w, r)
+			}
 			return
 		}
 		next.ServeHTTP(w, r)
 	})
 }
+
+func (app *application) requireActivatedUser(next http.Handler) http.HandlerFunc {
+	return app.verifyJWTMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user := app.contextGetUser(r)
+
+		if !app.checkAccountActive(w, r, user) {
+			return
+		}
+		next.ServeHTTP(w, r)
+	}))
+}
+
+// checkAccountActive writes an error response and returns false if the user
+// hasn't activated the account or has scheduled it for deletion.
+func (app *application) checkAccountActive(w http.ResponseWriter, r *http.Request, user *data.User) bool {
+	switch {
+	case !user.Activated:
+		app.inactiveAccountResponse(w, r)
+		return false
+	case user.DeletionScheduled():
+		app.deletionScheduledResponse(w, r)
+		return false
+	}
+	return true
+}
@@ -2,9 +2,13 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
+	"fmt"
+	"net/http"
 	"os"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -13,6 +17,10 @@ import (
 	"godvanced.forstes.github.com/internal/data"
 	"godvanced.forstes.github.com/internal/jsonlog"
 	"godvanced.forstes.github.com/internal/mailer"
+	"godvanced.forstes.github.com/internal/oidc"
+	"godvanced.forstes.github.com/internal/password"
+	"godvanced.forstes.github.com/internal/scoring"
+	"golang.org/x/time/rate"
 )
 
 const version = "1.0.0"
@@ -30,6 +38,26 @@ type config struct {
 		burst   int
 		enabled bool
 	}
+	cookies struct {
+		secure   bool
+		sameSite http.SameSite
+	}
+	trustedOrigins []string
+	lockout        struct {
+		threshold int
+		base      time.Duration
+		max       time.Duration
+	}
+	argon2id struct {
+		memory      uint
+		iterations  uint
+		parallelism uint
+	}
+	deletionGracePeriod time.Duration
+	scorer              string
+	twoFactor           struct {
+		requiredForAdmins bool
+	}
 	smtp struct {
 		host     string
 		port     int
@@ -37,6 +65,12 @@ type config struct {
 		password string
 		sender   string
 	}
+	oidc struct {
+		issuer       string
+		clientID     string
+		clientSecret string
+		redirectURL  string
+	}
 	jwtOptions *jwtOptions
 }
 type application struct {
@@ -44,7 +78,17 @@ type application struct {
 	logger *jsonlog.Logger
 	models data.Models
 	mailer mailer.Mailer
+	oidc   *oidc.Provider
+	scorer scoring.Scorer
 	wg     sync.WaitGroup
+
+	passwords *password.Hasher
+	// dummyPasswordHash is compared against when a login can't be checked
+	// against a real hash, so that every failed login costs the same work.
+	dummyPasswordHash string
+
+	emailLimiter *keyedLimiter
+	loginLimiter *keyedLimiter
 }
 
 func main() {
@@ -67,14 +111,63 @@ func main() {
 	flag.IntVar(&cfg.limiter.burst, "limiter-burst", 4, "Rate limiter maximum burst")
 	flag.BoolVar(&cfg.limiter.enabled, "limiter-enabled", true, "Enable rate limiter")
 
+	var cookieSameSite string
+	flag.BoolVar(&cfg.cookies.secure, "cookie-secure", false, "Set the Secure attribute on cookies, always on outside of development")
+	flag.StringVar(&cookieSameSite, "cookie-samesite", "lax", "SameSite attribute of cookies (lax|strict|none)")
+
+	flag.Func("trusted-origins", "Trusted origins for cookie-authenticated requests from other sites (space separated)", func(val string) error {
+		cfg.trustedOrigins = strings.Fields(val)
+		return nil
+	})
+
+	flag.IntVar(&cfg.lockout.threshold, "lockout-threshold", 5, "Failed logins in a row before an account is locked")
+	flag.DurationVar(&cfg.lockout.base, "lockout-base", 30*time.Second, "Duration of the first account lockout, doubled on every further failure")
+	flag.DurationVar(&cfg.lockout.max, "lockout-max", time.Hour, "Maximum duration of an account lockout")
+
+	flag.UintVar(&cfg.argon2id.memory, "argon2id-memory", 64*1024, "Memory used to hash a password with argon2id, in KiB")
+	flag.UintVar(&cfg.argon2id.iterations, "argon2id-iterations", 3, "Number of argon2id passes over the memory")
+	flag.UintVar(&cfg.argon2id.parallelism, "argon2id-parallelism", 2, "Number of threads used by argon2id")
+
+	flag.StringVar(&cfg.scorer, "scorer", "classic", fmt.Sprintf("Scorer rating new activity answers (%s)", strings.Join(scoring.Names(), "|")))
+
+	flag.DurationVar(&cfg.deletionGracePeriod, "deletion-grace-period", 30*24*time.Hour, "Time before a deleted account is purged")
+
+	flag.BoolVar(&cfg.twoFactor.requiredForAdmins, "require-admin-2fa", false, "Deny admin routes to admins without two-factor authentication")
+
 	flag.StringVar(&cfg.smtp.host, "smtp-host", "smtp.office365.com", "SMTP host")
 	flag.IntVar(&cfg.smtp.port, "smtp-port", 587, "SMTP port")
 	flag.StringVar(&cfg.smtp.username, "smtp-username", os.Getenv("SMTP_USERNAME"), "SMTP username")
 	flag.StringVar(&cfg.smtp.password, "smtp-password", os.Getenv("SMTP_PASSWORD"), "SMTP password")
 	flag.StringVar(&cfg.smtp.sender, "smtp-sender", os.Getenv("SMTP_SENDER"), "SMTP sender")
 
+	flag.StringVar(&cfg.oidc.issuer, "oidc-issuer", os.Getenv("OIDC_ISSUER"), "OpenID Connect provider issuer URL, empty to disable OIDC login")
+	flag.StringVar(&cfg.oidc.clientID, "oidc-client-id", os.Getenv("OIDC_CLIENT_ID"), "OpenID Connect client ID")
+	flag.StringVar(&cfg.oidc.clientSecret, "oidc-client-secret", os.Getenv("OIDC_CLIENT_SECRET"), "OpenID Connect client secret")
+	flag.StringVar(&cfg.oidc.redirectURL, "oidc-redirect-url", os.Getenv("OIDC_REDIRECT_URL"), "OpenID Connect redirect URL, ending in /v1/oidc/callback")
+
+	var jwtKeysDir, jwtSigningKID string
+	flag.StringVar(&jwtKeysDir, "jwt-keys-dir", os.Getenv("JWT_KEYS_DIR"), "Directory with <kid>.pem keys for signing JWTs (RS256 or EdDSA)")
+	flag.StringVar(&jwtSigningKID, "jwt-signing-kid", os.Getenv("JWT_SIGNING_KID"), "Key ID used to sign new JWTs")
+
 	flag.Parse()
 
+	if cfg.env != "development" {
+		cfg.cookies.secure = true
+	}
+
+	switch cookieSameSite {
+	case "lax":
+		cfg.cookies.sameSite = http.SameSiteLaxMode
+	case "strict":
+		cfg.cookies.sameSite = http.SameSiteStrictMode
+	case "none":
+		// Browsers drop SameSite=None cookies that aren't Secure.
+		cfg.cookies.sameSite = http.SameSiteNoneMode
+		cfg.cookies.secure = true
+	default:
+		logger.PrintFatal(fmt.Errorf("invalid cookie-samesite value %q", cookieSameSite), nil)
+	}
+
 	db, err := openDB(cfg)
 	if err != nil {
 		logger.PrintFatal(err, nil)
@@ -83,18 +176,86 @@ func main() {
 	defer db.Close()
 	logger.PrintInfo("database connection pool established", nil)
 
-	expireHours, err := strconv.Atoi(os.Getenv("JWT_EXPIRY_HOURS"))
+	// JWT_EXPIRY_HOURS set the token lifetime before there were refresh
+	// tokens, and was usually set to hours. Access tokens are meant to be
+	// short-lived now, so it's ignored rather than carried over.
+	if os.Getenv("JWT_EXPIRY_HOURS") != "" {
+		logger.PrintError(errors.New("JWT_EXPIRY_HOURS is no longer read, set JWT_EXPIRY_MINUTES instead"), nil)
+	}
+
+	expires, err := envDuration("JWT_EXPIRY_MINUTES", time.Minute, 15*time.Minute)
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+	refreshExpires, err := envDuration("REFRESH_EXPIRY_HOURS", time.Hour, 30*24*time.Hour)
 	if err != nil {
 		logger.PrintFatal(err, nil)
-		return
 	}
-	cfg.jwtOptions = &jwtOptions{key: os.Getenv("JWT_KEY"), expires: time.Duration(expireHours) * time.Hour}
+	cfg.jwtOptions = &jwtOptions{
+		key:            os.Getenv("JWT_KEY"),
+		expires:        expires,
+		refreshExpires: refreshExpires,
+	}
+
+	// Without a key every token would be signed with an empty secret and then
+	// rejected, so logins would seem to work but no session would.
+	if cfg.jwtOptions.key == "" && jwtKeysDir == "" {
+		logger.PrintFatal(errors.New("either JWT_KEY or JWT_KEYS_DIR must be set"), nil)
+	}
+
+	if jwtKeysDir != "" {
+		cfg.jwtOptions.keySet, err = loadJWTKeySet(jwtKeysDir, jwtSigningKID)
+		if err != nil {
+			logger.PrintFatal(err, nil)
+		}
+	}
 
 	app := &application{
 		config: cfg,
 		logger: logger,
 		models: data.NewModels(db),
 		mailer: mailer.New(cfg.smtp.host, cfg.smtp.port, cfg.smtp.username, cfg.smtp.password, cfg.smtp.sender),
+
+		emailLimiter: newKeyedLimiter(rate.Every(15*time.Minute), 3, time.Hour),
+		loginLimiter: newKeyedLimiter(rate.Every(time.Minute), 20, time.Hour),
+	}
+
+	var ok bool
+	app.scorer, ok = scoring.Latest(cfg.scorer)
+	if !ok {
+		logger.PrintFatal(fmt.Errorf("unknown scorer %q", cfg.scorer), nil)
+	}
+
+	app.passwords = password.NewHasher(
+		password.Argon2id{
+			Memory:      uint32(cfg.argon2id.memory),
+			Iterations:  uint32(cfg.argon2id.iterations),
+			Parallelism: uint8(cfg.argon2id.parallelism),
+			SaltLength:  16,
+			KeyLength:   32,
+		},
+		password.Bcrypt{Cost: 12},
+	)
+
+	app.dummyPasswordHash, err = app.passwords.Hash("dummy-password")
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+
+	if cfg.oidc.issuer != "" {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		app.oidc, err = oidc.New(ctx, oidc.Config{
+			Issuer:       cfg.oidc.issuer,
+			ClientID:     cfg.oidc.clientID,
+			ClientSecret: cfg.oidc.clientSecret,
+			RedirectURL:  cfg.oidc.redirectURL,
+		})
+		if err != nil {
+			logger.PrintFatal(err, nil)
+		}
+		logger.PrintInfo("oidc provider configured", map[string]string{"issuer": cfg.oidc.issuer})
 	}
 
 	err = app.serve()
@@ -129,3 +290,18 @@ func openDB(cfg config) (*pgxpool.Pool, error) {
 	}
 	return db, nil
 }
+
+// envDuration reads a whole number of units from the named environment
+// variable, or returns fallback if the variable isn't set.
+func envDuration(name string, unit, fallback time.Duration) (time.Duration, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback, nil
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 1 {
+		return 0, fmt.Errorf("%s must be a positive whole number", name)
+	}
+	return time.Duration(n) * unit, nil
+}
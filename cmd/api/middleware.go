@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"net"
 	"net/http"
+	"net/url"
 	"sync"
 	"time"
 
@@ -78,3 +79,61 @@ func (app *application) rateLimit(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r)
 	})
 }
+
+// csrfProtect rejects cross-site requests that change state. The browser
+// attaches the session cookies to those requests on its own, so they could be
+// forged by any site the user visits. Requests with an Authorization header
+// don't rely on cookies and are exempt.
+func (app *application) csrfProtect(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if r.Header.Get("Authorization") != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if !app.sameOriginRequest(r) {
+			app.crossSiteRequestResponse(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// sameOriginRequest checks the Sec-Fetch-Site header sent by current browsers
+// and falls back to the Origin header. Requests with neither don't come from a
+// browser and are let through.
+func (app *application) sameOriginRequest(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+
+	if origin != "" {
+		for _, trusted := range app.config.trustedOrigins {
+			if origin == trusted {
+				return true
+			}
+		}
+	}
+
+	switch r.Header.Get("Sec-Fetch-Site") {
+	case "same-origin", "none":
+		return true
+	case "same-site", "cross-site":
+		return false
+	}
+
+	if origin == "" {
+		return true
+	}
+
+	originURL, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	return originURL.Host == r.Host
+}
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"godvanced.forstes.github.com/internal/data"
+	"godvanced.forstes.github.com/internal/oidc"
+	"godvanced.forstes.github.com/internal/validator"
+)
+
+// The state, nonce and PKCE verifier of a sign-in in progress are kept in a
+// short-lived cookie scoped to the OIDC routes.
+const oidcFlowCookie = "oidc_flow"
+
+// A reauthentication only counts right after the user signed in at the
+// provider, and its token is only good for a few minutes after that.
+const reauthenticationTTL = 5 * time.Minute
+
+var errUnverifiedEmail = errors.New("email not verified by the identity provider")
+
+func (app *application) oidcLoginHandler(w http.ResponseWriter, r *http.Request) {
+	if app.oidc == nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	app.startOIDCFlow(w, r, "")
+}
+
+// oidcReauthenticateHandler sends a signed in user to the provider to sign in
+// again. Coming back, they get a reauthentication token, which stands in for
+// their password where it's asked for.
+func (app *application) oidcReauthenticateHandler(w http.ResponseWriter, r *http.Request) {
+	if app.oidc == nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	app.startOIDCFlow(w, r, strconv.FormatInt(user.ID, 10))
+}
+
+// startOIDCFlow redirects to the provider. For a reauthentication the ID of the
+// user is kept in the flow cookie after the state, nonce and verifier.
+func (app *application) startOIDCFlow(w http.ResponseWriter, r *http.Request, reauthUserID string) {
+	var values [3]string
+	for i := range values {
+		value, err := oidc.RandomString()
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		values[i] = value
+	}
+	state, nonce, verifier := values[0], values[1], values[2]
+
+	flow := values[:]
+	authURL := app.oidc.AuthCodeURL(state, nonce, verifier)
+	if reauthUserID != "" {
+		flow = append(flow, reauthUserID)
+		authURL = app.oidc.ReauthenticationURL(state, nonce, verifier)
+	}
+
+	// The provider redirects back with a cross-site navigation, which a
+	// strict cookie wouldn't be sent with.
+	cookie := app.newCookie(oidcFlowCookie, strings.Join(flow, "."), "/v1/oidc")
+	cookie.MaxAge = 10 * 60
+	if cookie.SameSite == http.SameSiteStrictMode {
+		cookie.SameSite = http.SameSiteLaxMode
+	}
+	http.SetCookie(w, cookie)
+
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+func (app *application) oidcCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	if app.oidc == nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	cookie, err := r.Cookie(oidcFlowCookie)
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("no sign-in in progress"))
+		return
+	}
+	app.clearCookie(w, oidcFlowCookie, "/v1/oidc")
+
+	values := strings.Split(cookie.Value, ".")
+	if len(values) != 3 && len(values) != 4 {
+		app.badRequestResponse(w, r, errors.New("no sign-in in progress"))
+		return
+	}
+	state, nonce, verifier := values[0], values[1], values[2]
+
+	qs := r.URL.Query()
+
+	if subtle.ConstantTimeCompare([]byte(qs.Get("state")), []byte(state)) != 1 {
+		app.badRequestResponse(w, r, errors.New("state doesn't match the sign-in in progress"))
+		return
+	}
+
+	if qs.Get("error") != "" {
+		app.badRequestResponse(w, r, errors.New("identity provider: "+qs.Get("error")))
+		return
+	}
+
+	claims, err := app.oidc.Exchange(r.Context(), qs.Get("code"), verifier)
+	if err != nil {
+		app.logger.PrintError(err, nil)
+		app.invalidCredentialsResponse(w, r)
+		return
+	}
+
+	if subtle.ConstantTimeCompare([]byte(claims.Nonce), []byte(nonce)) != 1 {
+		app.invalidCredentialsResponse(w, r)
+		return
+	}
+
+	if len(values) == 4 {
+		app.finishOIDCReauthentication(w, r, claims, values[3])
+		return
+	}
+
+	user, err := app.userForIdentity(claims)
+	if err != nil {
+		switch {
+		case errors.Is(err, errUnverifiedEmail):
+			v := validator.New()
+			v.AddError("email", "must be verified by the identity provider")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if user.IsLocked() {
+		app.invalidCredentialsResponse(w, r)
+		return
+	}
+
+	app.finishLogin(w, r, user, false)
+}
+
+// finishOIDCReauthentication hands out a reauthentication token if the user
+// just signed in again as an identity linked to their account.
+func (app *application) finishOIDCReauthentication(w http.ResponseWriter, r *http.Request, claims *oidc.Claims, reauthUserID string) {
+	userID, err := strconv.ParseInt(reauthUserID, 10, 64)
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("no sign-in in progress"))
+		return
+	}
+
+	// A provider that didn't ask the user to sign in again doesn't prove
+	// it's still them.
+	if claims.AuthTime.IsZero() || time.Since(claims.AuthTime) > reauthenticationTTL {
+		app.invalidCredentialsResponse(w, r)
+		return
+	}
+
+	user, err := app.models.Users.GetForIdentity(app.oidc.Issuer(), claims.Subject)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidCredentialsResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if user.ID != userID {
+		app.invalidCredentialsResponse(w, r)
+		return
+	}
+
+	token, err := app.models.Tokens.New(user.ID, reauthenticationTTL, data.ScopeReauthentication)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	env := envelope{
+		"reauthentication_token": token.Plaintext,
+		"expiry":                 token.Expiry,
+	}
+
+	err = app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// userForIdentity returns the user linked to the external identity. An
+// unknown identity is linked to the user with the same email, or to a new
+// user, but only if the provider has verified the email address.
+//
+// Anyone can register an email address they don't own and leave the account
+// unactivated, waiting for the owner to sign in through the provider. So an
+// unactivated account is reclaimed before it's linked: the provider vouches
+// for the email, and the password and sessions of whoever registered it stop
+// working.
+func (app *application) userForIdentity(claims *oidc.Claims) (*data.User, error) {
+	user, err := app.models.Users.GetForIdentity(app.oidc.Issuer(), claims.Subject)
+	if err == nil {
+		return user, nil
+	}
+	if !errors.Is(err, data.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	if claims.Email == "" || !claims.EmailVerified {
+		return nil, errUnverifiedEmail
+	}
+
+	user, err = app.models.Users.GetUser(claims.Email)
+	switch {
+	case errors.Is(err, data.ErrRecordNotFound):
+		user, err = app.createOIDCUser(claims)
+		if err != nil {
+			return nil, err
+		}
+	case err != nil:
+		return nil, err
+	case !user.Activated:
+		hash, err := app.randomPasswordHash()
+		if err != nil {
+			return nil, err
+		}
+
+		err = app.models.Users.Reclaim(user, hash)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	identity := &data.Identity{
+		UserID:   user.ID,
+		Provider: app.oidc.Issuer(),
+		Subject:  claims.Subject,
+		Email:    claims.Email,
+	}
+
+	err = app.models.Identities.Insert(identity)
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// createOIDCUser registers a user that signs in through the identity provider
+// only. It gets a random password nobody knows, which can be replaced through
+// the password reset.
+func (app *application) createOIDCUser(claims *oidc.Claims) (*data.User, error) {
+	hash, err := app.randomPasswordHash()
+	if err != nil {
+		return nil, err
+	}
+
+	name := claims.Name
+	if name == "" {
+		name, _, _ = strings.Cut(claims.Email, "@")
+	}
+	if len(name) > 50 {
+		name = name[:50]
+	}
+
+	user := &data.User{
+		Email:    claims.Email,
+		Name:     name,
+		Password: hash,
+	}
+
+	// Names are unique, so a taken name gets a random suffix.
+	err = app.models.Users.InsertUser(user)
+	if errors.Is(err, data.ErrDuplicateName) {
+		var suffix string
+
+		suffix, err = oidc.RandomString()
+		if err != nil {
+			return nil, err
+		}
+		user.Name = name + "-" + suffix[:6]
+
+		err = app.models.Users.InsertUser(user)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	user.Activated = true
+
+	err = app.models.Users.UpdateUser(user)
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// randomPasswordHash hashes a random password nobody knows, for accounts that
+// are only signed in to through the identity provider.
+func (app *application) randomPasswordHash() (string, error) {
+	plaintext, err := oidc.RandomString()
+	if err != nil {
+		return "", err
+	}
+	return app.passwords.Hash(plaintext)
+}
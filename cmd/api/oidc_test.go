@@ -0,0 +1,393 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"godvanced.forstes.github.com/internal/data"
+	"godvanced.forstes.github.com/internal/jsonlog"
+	"godvanced.forstes.github.com/internal/oidc"
+	"godvanced.forstes.github.com/internal/oidc/oidctest"
+	"godvanced.forstes.github.com/internal/password"
+	"godvanced.forstes.github.com/internal/validator"
+)
+
+// newOIDCTestApp returns an application signing in through a fake provider.
+// Its models are only usable with withDB.
+func newOIDCTestApp(t *testing.T) (*application, *oidctest.Server) {
+	t.Helper()
+
+	server := oidctest.NewServer("client", "secret")
+	t.Cleanup(server.Close)
+
+	provider, err := oidc.New(context.Background(), oidc.Config{
+		Issuer:       server.URL,
+		ClientID:     "client",
+		ClientSecret: "secret",
+		RedirectURL:  "http://localhost/v1/oidc/callback",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app := &application{
+		config: config{
+			jwtOptions: &jwtOptions{key: "test-key", expires: time.Minute, refreshExpires: time.Hour},
+		},
+		logger:    jsonlog.New(io.Discard, jsonlog.LevelInfo),
+		oidc:      provider,
+		passwords: password.NewHasher(password.Bcrypt{Cost: 4}),
+	}
+	return app, server
+}
+
+// withDB gives the application a database to work with. The tests using it
+// are skipped unless TEST_DB_DSN points at a database migrated with
+// ./migrations.
+func withDB(t *testing.T, app *application) {
+	t.Helper()
+
+	dsn := os.Getenv("TEST_DB_DSN")
+	if dsn == "" {
+		t.Skip("TEST_DB_DSN not set")
+	}
+
+	db, err := pgxpool.New(context.Background(), dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(db.Close)
+
+	app.models = data.NewModels(db)
+}
+
+// startOIDCLogin runs the login handler and follows its redirect to the fake
+// provider. It returns the flow cookie and the query the provider redirects
+// back to the callback with.
+func startOIDCLogin(t *testing.T, app *application) (*http.Cookie, url.Values) {
+	t.Helper()
+
+	r := httptest.NewRequest(http.MethodGet, "/v1/oidc/login", nil)
+	return startOIDCFlow(t, app.oidcLoginHandler, r)
+}
+
+// startOIDCReauthentication is like startOIDCLogin for a reauthentication of
+// the signed in user.
+func startOIDCReauthentication(t *testing.T, app *application, user *data.User) (*http.Cookie, url.Values) {
+	t.Helper()
+
+	r := httptest.NewRequest(http.MethodGet, "/v1/oidc/reauthenticate", nil)
+	return startOIDCFlow(t, app.oidcReauthenticateHandler, app.contextSetUser(r, user))
+}
+
+func startOIDCFlow(t *testing.T, handler http.HandlerFunc, r *http.Request) (*http.Cookie, url.Values) {
+	t.Helper()
+
+	rr := httptest.NewRecorder()
+	handler(rr, r)
+
+	res := rr.Result()
+	if res.StatusCode != http.StatusFound {
+		t.Fatalf("start: got status %d, want %d", res.StatusCode, http.StatusFound)
+	}
+
+	var flow *http.Cookie
+	for _, cookie := range res.Cookies() {
+		if cookie.Name == oidcFlowCookie {
+			flow = cookie
+		}
+	}
+	if flow == nil {
+		t.Fatal("start: no flow cookie set")
+	}
+
+	client := &http.Client{
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+
+	providerRes, err := client.Get(res.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	providerRes.Body.Close()
+
+	callback, err := url.Parse(providerRes.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return flow, callback.Query()
+}
+
+func oidcCallback(app *application, flow *http.Cookie, qs url.Values) *http.Response {
+	r := httptest.NewRequest(http.MethodGet, "/v1/oidc/callback?"+qs.Encode(), nil)
+	r.AddCookie(flow)
+
+	rr := httptest.NewRecorder()
+	app.oidcCallbackHandler(rr, r)
+	return rr.Result()
+}
+
+// replaceFlowValue swaps one of the state, nonce and verifier kept in the flow
+// cookie, in that order.
+func replaceFlowValue(flow *http.Cookie, i int, value string) *http.Cookie {
+	values := strings.Split(flow.Value, ".")
+	values[i] = value
+	return &http.Cookie{Name: flow.Name, Value: strings.Join(values, ".")}
+}
+
+func TestOIDCCallbackRejectsTamperedFlow(t *testing.T) {
+	tests := []struct {
+		name       string
+		tamper     func(flow *http.Cookie, qs url.Values) *http.Cookie
+		wantStatus int
+	}{
+		{
+			name: "wrong state",
+			tamper: func(flow *http.Cookie, qs url.Values) *http.Cookie {
+				qs.Set("state", "forged-state")
+				return flow
+			},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name: "wrong code_verifier",
+			tamper: func(flow *http.Cookie, qs url.Values) *http.Cookie {
+				return replaceFlowValue(flow, 2, "forged-verifier")
+			},
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name: "wrong nonce",
+			tamper: func(flow *http.Cookie, qs url.Values) *http.Cookie {
+				return replaceFlowValue(flow, 1, "forged-nonce")
+			},
+			wantStatus: http.StatusUnauthorized,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, _ := newOIDCTestApp(t)
+
+			flow, qs := startOIDCLogin(t, app)
+			flow = tt.tamper(flow, qs)
+
+			res := oidcCallback(app, flow, qs)
+			if res.StatusCode != tt.wantStatus {
+				t.Errorf("got status %d, want %d", res.StatusCode, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestOIDCCallbackRejectsUnverifiedEmail(t *testing.T) {
+	app, server := newOIDCTestApp(t)
+	withDB(t, app)
+
+	email := fmt.Sprintf("unverified-%d@example.com", time.Now().UnixNano())
+	server.SetUser(oidctest.User{Subject: email, Email: email, EmailVerified: false})
+
+	flow, qs := startOIDCLogin(t, app)
+
+	res := oidcCallback(app, flow, qs)
+	if res.StatusCode != http.StatusUnprocessableEntity {
+		t.Errorf("got status %d, want %d", res.StatusCode, http.StatusUnprocessableEntity)
+	}
+
+	_, err := app.models.Users.GetUser(email)
+	if !errors.Is(err, data.ErrRecordNotFound) {
+		t.Errorf("got error %v, want no user created", err)
+	}
+}
+
+func TestOIDCCallbackLinksActivatedUser(t *testing.T) {
+	app, server := newOIDCTestApp(t)
+	withDB(t, app)
+
+	email := fmt.Sprintf("activated-%d@example.com", time.Now().UnixNano())
+
+	hash, err := app.passwords.Hash("owner-password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user := &data.User{Email: email, Name: email, Password: hash}
+	err = app.models.Users.InsertUser(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { app.models.Users.DeleteUser(user.ID) })
+
+	user.Activated = true
+	err = app.models.Users.UpdateUser(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server.SetUser(oidctest.User{Subject: email, Email: email, EmailVerified: true})
+
+	flow, qs := startOIDCLogin(t, app)
+
+	res := oidcCallback(app, flow, qs)
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("got status %d, want %d", res.StatusCode, http.StatusOK)
+	}
+
+	linked, err := app.models.Users.GetForIdentity(app.oidc.Issuer(), email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if linked.ID != user.ID {
+		t.Errorf("identity linked to user %d, want %d", linked.ID, user.ID)
+	}
+
+	// The owner's own password keeps working.
+	err = app.passwords.Compare(linked.Password, "owner-password")
+	if err != nil {
+		t.Errorf("owner password: %v", err)
+	}
+}
+
+// An attacker registers the victim's email with their own password and never
+// activates the account. When the victim signs in through the provider, the
+// account is theirs and nothing the attacker set up works any more.
+func TestOIDCCallbackReclaimsUnactivatedUser(t *testing.T) {
+	app, server := newOIDCTestApp(t)
+	withDB(t, app)
+
+	email := fmt.Sprintf("victim-%d@example.com", time.Now().UnixNano())
+
+	hash, err := app.passwords.Hash("attacker-password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user := &data.User{Email: email, Name: email, Password: hash}
+	err = app.models.Users.InsertUser(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { app.models.Users.DeleteUser(user.ID) })
+
+	refreshToken, err := app.models.Tokens.New(user.ID, time.Hour, data.ScopeRefresh)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	apiKey := &data.APIKey{UserID: user.ID, Name: "attacker", Scopes: data.Permissions{}}
+	err = app.models.APIKeys.New(apiKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server.SetUser(oidctest.User{Subject: email, Email: email, EmailVerified: true})
+
+	flow, qs := startOIDCLogin(t, app)
+
+	res := oidcCallback(app, flow, qs)
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("got status %d, want %d", res.StatusCode, http.StatusOK)
+	}
+
+	reclaimed, err := app.models.Users.GetUser(email)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reclaimed.Activated {
+		t.Error("account not activated")
+	}
+
+	err = app.passwords.Compare(reclaimed.Password, "attacker-password")
+	if !errors.Is(err, password.ErrMismatchedHashAndPassword) {
+		t.Errorf("attacker password: got %v, want a mismatch", err)
+	}
+
+	_, err = app.models.Tokens.UseRefresh(refreshToken.Plaintext)
+	if !errors.Is(err, data.ErrRecordNotFound) {
+		t.Errorf("attacker refresh token: got %v, want it revoked", err)
+	}
+
+	_, err = app.models.APIKeys.Use(apiKey.Plaintext)
+	if !errors.Is(err, data.ErrRecordNotFound) {
+		t.Errorf("attacker API key: got %v, want it revoked", err)
+	}
+}
+
+// A user who only signs in through the provider proves it's them by signing
+// in there again, and gets a token that stands in for their password once.
+func TestOIDCReauthentication(t *testing.T) {
+	app, server := newOIDCTestApp(t)
+	withDB(t, app)
+
+	email := fmt.Sprintf("reauth-%d@example.com", time.Now().UnixNano())
+	server.SetUser(oidctest.User{Subject: email, Email: email, EmailVerified: true})
+
+	flow, qs := startOIDCLogin(t, app)
+
+	res := oidcCallback(app, flow, qs)
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("login: got status %d, want %d", res.StatusCode, http.StatusOK)
+	}
+
+	user, err := app.models.Users.GetForIdentity(app.oidc.Issuer(), email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { app.models.Users.DeleteUser(user.ID) })
+
+	// Another user can't reauthenticate as this identity.
+	flow, qs = startOIDCReauthentication(t, app, &data.User{ID: user.ID + 1_000_000})
+
+	res = oidcCallback(app, flow, qs)
+	if res.StatusCode != http.StatusUnauthorized {
+		t.Errorf("other user: got status %d, want %d", res.StatusCode, http.StatusUnauthorized)
+	}
+
+	flow, qs = startOIDCReauthentication(t, app, user)
+
+	res = oidcCallback(app, flow, qs)
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("reauthenticate: got status %d, want %d", res.StatusCode, http.StatusOK)
+	}
+
+	var body struct {
+		ReauthenticationToken string `json:"reauthentication_token"`
+	}
+
+	err = json.NewDecoder(res.Body).Decode(&body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v := validator.New()
+
+	err = app.confirmIdentity(v, user, "password", "", body.ReauthenticationToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !v.Valid() {
+		t.Errorf("got errors %v, want the token accepted", v.Errors)
+	}
+
+	v = validator.New()
+
+	err = app.confirmIdentity(v, user, "password", "", body.ReauthenticationToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.Valid() {
+		t.Error("token accepted twice")
+	}
+}
@@ -10,8 +10,10 @@ import (
 
 func (app *application) createQuestionHandler(w http.ResponseWriter, r *http.Request) {
 	var input struct {
-		Title   string         `json:"title"`
-		Answers []*data.Answer `json:"answers"`
+		Title     string         `json:"title"`
+		Weight    *int16         `json:"weight"`
+		Dimension string         `json:"dimension"`
+		Answers   []*data.Answer `json:"answers"`
 	}
 
 	err := app.readJSON(w, r, &input)
@@ -21,8 +23,13 @@ func (app *application) createQuestionHandler(w http.ResponseWriter, r *http.Req
 	}
 
 	question := &data.Question{
-		Title:   input.Title,
-		Answers: input.Answers,
+		Title:     input.Title,
+		Weight:    1,
+		Dimension: input.Dimension,
+		Answers:   input.Answers,
+	}
+	if input.Weight != nil {
+		question.Weight = *input.Weight
 	}
 
 	v := validator.New()
@@ -101,8 +108,10 @@ func (app *application) updateQuestionHandler(w http.ResponseWriter, r *http.Req
 	}
 
 	var input struct {
-		Title    *string `json:"title"`
-		VideoURL *string `json:"video_url"`
+		Title     *string `json:"title"`
+		VideoURL  *string `json:"video_url"`
+		Weight    *int16  `json:"weight"`
+		Dimension *string `json:"dimension"`
 	}
 
 	err = app.readJSON(w, r, &input)
@@ -115,6 +124,12 @@ func (app *application) updateQuestionHandler(w http.ResponseWriter, r *http.Req
 	if input.VideoURL != nil {
 		question.VideoUrl = *input.VideoURL
 	}
+	if input.Weight != nil {
+		question.Weight = *input.Weight
+	}
+	if input.Dimension != nil {
+		question.Dimension = *input.Dimension
+	}
 
 	v := validator.New()
 	if data.ValidateQuestion(v, question); !v.Valid() {
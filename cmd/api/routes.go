@@ -13,27 +13,67 @@ func (app *application) routes() http.Handler {
 	router.MethodNotAllowed = http.HandlerFunc(app.methodNotAllowedResponse)
 
 	router.HandlerFunc(http.MethodGet, "/v1/healthcheck", app.healthcheckHandler)
+	router.HandlerFunc(http.MethodGet, "/.well-known/jwks.json", app.jwksHandler)
 
 	router.HandlerFunc(http.MethodPost, "/v1/register", app.register)
 	router.HandlerFunc(http.MethodPost, "/v1/login", app.login)
-	router.HandlerFunc(http.MethodGet, "/v1/logout", app.logout)
+	router.HandlerFunc(http.MethodPost, "/v1/login/2fa", app.loginTwoFactorHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/oidc/login", app.oidcLoginHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/oidc/callback", app.oidcCallbackHandler)
+	router.Handler(http.MethodGet, "/v1/oidc/reauthenticate", app.verifyJWTMiddleware(http.HandlerFunc(app.oidcReauthenticateHandler)))
+	router.HandlerFunc(http.MethodPost, "/v1/logout", app.logout)
+	router.Handler(http.MethodPost, "/v1/logout/all", app.verifyJWTMiddleware(http.HandlerFunc(app.logoutEverywhere)))
 	router.HandlerFunc(http.MethodPut, "/v1/user/activated", app.activateUserHandler)
+	router.HandlerFunc(http.MethodPut, "/v1/users/password", app.updateUserPasswordHandler)
+	router.Handler(http.MethodGet, "/v1/users/me", app.verifyJWTMiddleware(http.HandlerFunc(app.showCurrentUserHandler)))
+	router.Handler(http.MethodPatch, "/v1/users/me", app.verifyJWTMiddleware(http.HandlerFunc(app.updateCurrentUserHandler)))
+	router.Handler(http.MethodDelete, "/v1/users/me", app.verifyJWTMiddleware(http.HandlerFunc(app.deleteCurrentUserHandler)))
+	router.Handler(http.MethodPost, "/v1/users/me/restore", app.verifyJWTMiddleware(http.HandlerFunc(app.restoreCurrentUserHandler)))
+	router.Handler(http.MethodGet, "/v1/users/me/export", app.verifyJWTMiddleware(http.HandlerFunc(app.exportCurrentUserHandler)))
+	router.Handler(http.MethodPut, "/v1/users/me/password", app.verifyJWTMiddleware(http.HandlerFunc(app.updateCurrentUserPasswordHandler)))
+	router.Handler(http.MethodPost, "/v1/users/me/email", app.verifyJWTMiddleware(http.HandlerFunc(app.createEmailChangeTokenHandler)))
+	router.HandlerFunc(http.MethodPut, "/v1/users/me/email", app.confirmEmailChangeHandler)
+	router.Handler(http.MethodGet, "/v1/users/me/sessions", app.verifyJWTMiddleware(http.HandlerFunc(app.listSessionsHandler)))
+	router.Handler(http.MethodDelete, "/v1/users/me/sessions/:id", app.verifyJWTMiddleware(http.HandlerFunc(app.deleteSessionHandler)))
+	router.Handler(http.MethodGet, "/v1/users/me/api-keys", app.requireActivatedUser(http.HandlerFunc(app.listAPIKeysHandler)))
+	router.Handler(http.MethodPost, "/v1/users/me/api-keys", app.requireActivatedUser(http.HandlerFunc(app.createAPIKeyHandler)))
+	router.Handler(http.MethodDelete, "/v1/users/me/api-keys/:id", app.requireActivatedUser(http.HandlerFunc(app.deleteAPIKeyHandler)))
+	router.Handler(http.MethodPost, "/v1/users/2fa/setup", app.verifyJWTMiddleware(http.HandlerFunc(app.setupTwoFactorHandler)))
+	router.Handler(http.MethodPost, "/v1/users/2fa/confirm", app.verifyJWTMiddleware(http.HandlerFunc(app.confirmTwoFactorHandler)))
+	router.Handler(http.MethodPost, "/v1/users/2fa/disable", app.verifyJWTMiddleware(http.HandlerFunc(app.disableTwoFactorHandler)))
+	router.Handler(http.MethodPost, "/v1/users/2fa/recovery-codes", app.verifyJWTMiddleware(http.HandlerFunc(app.regenerateRecoveryCodesHandler)))
 
-	router.Handler(http.MethodGet, "/v1/questions", app.verifyJWTMiddleware(http.HandlerFunc(app.listQuestionsHandler)))
-	router.Handler(http.MethodGet, "/v1/ikigais", app.JWTAdminOnlyMiddleware(http.HandlerFunc(app.listUserIkigaisHandler)))
-	router.Handler(http.MethodPost, "/v1/questions", app.JWTAdminOnlyMiddleware(http.HandlerFunc(app.createQuestionHandler)))
-	router.Handler(http.MethodPatch, "/v1/questions/:id", app.JWTAdminOnlyMiddleware(http.HandlerFunc(app.updateQuestionHandler)))
-	router.Handler(http.MethodDelete, "/v1/questions/:id", app.JWTAdminOnlyMiddleware(http.HandlerFunc(app.deleteQuestionHandler)))
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/activation", app.createActivationTokenHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/password-reset", app.createPasswordResetTokenHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/refresh", app.refreshTokenHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/magic-link", app.createMagicLinkTokenHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/magic-link/redeem", app.redeemMagicLinkTokenHandler)
 
-	router.Handler(http.MethodGet, "/v1/answers", app.verifyJWTMiddleware(http.HandlerFunc(app.listAnswersHandler)))
-	router.Handler(http.MethodPost, "/v1/answers", app.JWTAdminOnlyMiddleware(http.HandlerFunc(app.createAnswerHandler)))
-	router.Handler(http.MethodPut, "/v1/answers/:id", app.JWTAdminOnlyMiddleware(http.HandlerFunc(app.updateAnswerHandler)))
-	router.Handler(http.MethodDelete, "/v1/answers/:id", app.JWTAdminOnlyMiddleware(http.HandlerFunc(app.deleteAnswerHandler)))
+	router.Handler(http.MethodGet, "/v1/questions", app.requireActivatedUser(http.HandlerFunc(app.listQuestionsHandler)))
+	router.Handler(http.MethodGet, "/v1/ikigais", app.requirePermission("ikigais:read", http.HandlerFunc(app.listUserIkigaisHandler)))
+	router.Handler(http.MethodPost, "/v1/questions", app.requirePermission("questions:write", http.HandlerFunc(app.createQuestionHandler)))
+	router.Handler(http.MethodPatch, "/v1/questions/:id", app.requirePermission("questions:write", http.HandlerFunc(app.updateQuestionHandler)))
+	router.Handler(http.MethodDelete, "/v1/questions/:id", app.requirePermission("questions:write", http.HandlerFunc(app.deleteQuestionHandler)))
 
-	router.Handler(http.MethodGet, "/v1/admin/activities", app.JWTAdminOnlyMiddleware(http.HandlerFunc(app.listUserActivitiesHandler)))
-	router.Handler(http.MethodGet, "/v1/activities", app.verifyJWTMiddleware(http.HandlerFunc(app.listActivitiesHandler)))
-	router.Handler(http.MethodPost, "/v1/activities", app.verifyJWTMiddleware(http.HandlerFunc(app.createActivityHandler)))
-	router.Handler(http.MethodPatch, "/v1/activities/:id", app.verifyJWTMiddleware(http.HandlerFunc(app.updateActivityHandler)))
+	router.Handler(http.MethodGet, "/v1/answers", app.requireActivatedUser(http.HandlerFunc(app.listAnswersHandler)))
+	router.Handler(http.MethodPost, "/v1/answers", app.requirePermission("questions:write", http.HandlerFunc(app.createAnswerHandler)))
+	router.Handler(http.MethodPut, "/v1/answers/:id", app.requirePermission("questions:write", http.HandlerFunc(app.updateAnswerHandler)))
+	router.Handler(http.MethodDelete, "/v1/answers/:id", app.requirePermission("questions:write", http.HandlerFunc(app.deleteAnswerHandler)))
 
-	return app.recoverPanic(app.rateLimit(router))
+	router.Handler(http.MethodGet, "/v1/admin/users", app.requirePermission("users:manage", http.HandlerFunc(app.listUsersHandler)))
+	router.Handler(http.MethodGet, "/v1/admin/users/:id", app.requirePermission("users:manage", http.HandlerFunc(app.showUserHandler)))
+	router.Handler(http.MethodPatch, "/v1/admin/users/:id", app.requirePermission("users:manage", http.HandlerFunc(app.updateUserHandler)))
+	router.Handler(http.MethodDelete, "/v1/admin/users/:id", app.requirePermission("users:manage", http.HandlerFunc(app.deleteUserHandler)))
+	router.Handler(http.MethodPut, "/v1/admin/users/:id/permissions", app.requirePermission("users:manage", http.HandlerFunc(app.updateUserPermissionsHandler)))
+
+	router.Handler(http.MethodGet, "/v1/admin/activities", app.requirePermission("activities:read-all", http.HandlerFunc(app.listUserActivitiesHandler)))
+	router.Handler(http.MethodGet, "/v1/activities", app.requireActivatedUser(http.HandlerFunc(app.listActivitiesHandler)))
+	router.Handler(http.MethodPost, "/v1/activities", app.requireActivatedUser(http.HandlerFunc(app.createActivityHandler)))
+	router.Handler(http.MethodPatch, "/v1/activities/:id", app.requireActivatedUser(http.HandlerFunc(app.updateActivityHandler)))
+	router.Handler(http.MethodGet, "/v1/activities/:id/explanation", app.requireActivatedUser(http.HandlerFunc(app.explainActivityHandler)))
+	router.Handler(http.MethodPost, "/v1/activities/simulate", app.requireActivatedUser(http.HandlerFunc(app.simulateActivityHandler)))
+	router.Handler(http.MethodPut, "/v1/activities/:id/status", app.requirePermission("activities:override-status", http.HandlerFunc(app.overrideActivityStatusHandler)))
+	router.Handler(http.MethodGet, "/v1/activities/:id/status-overrides", app.requirePermission("activities:override-status", http.HandlerFunc(app.listActivityStatusOverridesHandler)))
+
+	return app.recoverPanic(app.rateLimit(app.csrfProtect(app.authenticate(router))))
 }
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+	"godvanced.forstes.github.com/internal/data"
+)
+
+func (app *application) listSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	var current string
+	if cookie, err := r.Cookie("refresh_token"); err == nil {
+		current = cookie.Value
+	}
+
+	sessions, err := app.models.Tokens.GetSessionsForUser(user.ID, current)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"sessions": sessions}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// deleteSessionHandler logs one of the user's sessions out. Its access token
+// stays valid until it expires, like after a logout.
+func (app *application) deleteSessionHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	id := httprouter.ParamsFromContext(r.Context()).ByName("id")
+
+	// Revoking the session the request comes from also clears its cookies.
+	var revokesCurrent bool
+	if cookie, err := r.Cookie("refresh_token"); err == nil {
+		sessions, err := app.models.Tokens.GetSessionsForUser(user.ID, cookie.Value)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		for _, session := range sessions {
+			if session.ID == id && session.Current {
+				revokesCurrent = true
+			}
+		}
+	}
+
+	err := app.models.Tokens.DeleteSession(user.ID, id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if revokesCurrent {
+		app.clearSession(w)
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "session successfully revoked"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
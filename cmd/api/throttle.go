@@ -0,0 +1,76 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// keyedLimiter is a token bucket limiter per arbitrary key (email address, IP
+// and so on). Keys that haven't been seen for a while are dropped.
+type keyedLimiter struct {
+	limit rate.Limit
+	burst int
+
+	mu      sync.Mutex
+	clients map[string]*limitedClient
+}
+
+type limitedClient struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+func newKeyedLimiter(limit rate.Limit, burst int, idle time.Duration) *keyedLimiter {
+	l := &keyedLimiter{
+		limit:   limit,
+		burst:   burst,
+		clients: make(map[string]*limitedClient),
+	}
+
+	go func() {
+		for {
+			time.Sleep(time.Minute)
+
+			l.mu.Lock()
+
+			for key, client := range l.clients {
+				if time.Since(client.lastSeen) > idle {
+					delete(l.clients, key)
+				}
+			}
+
+			l.mu.Unlock()
+		}
+	}()
+
+	return l
+}
+
+func (l *keyedLimiter) Allow(key string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	client, found := l.clients[key]
+	if !found {
+		client = &limitedClient{limiter: rate.NewLimiter(l.limit, l.burst)}
+		l.clients[key] = client
+	}
+
+	client.lastSeen = time.Now()
+
+	return client.limiter.Allow()
+}
+
+// Exhausted reports whether the key has no tokens left, without taking one.
+func (l *keyedLimiter) Exhausted(key string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	client, found := l.clients[key]
+	if !found {
+		return false
+	}
+	return client.limiter.Tokens() < 1
+}
@@ -0,0 +1,383 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"godvanced.forstes.github.com/internal/data"
+	"godvanced.forstes.github.com/internal/totp"
+	"godvanced.forstes.github.com/internal/validator"
+)
+
+const (
+	totpIssuer          = "Godvanced"
+	recoveryCodesNumber = 10
+	recoveryCodeTTL     = 10 * 365 * 24 * time.Hour
+)
+
+// setupTwoFactorHandler starts enrolling an authenticator app. It asks for the
+// current password (or a reauthentication), so a stolen access token isn't
+// enough to enroll one.
+func (app *application) setupTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	var input struct {
+		CurrentPassword       string `json:"current_password"`
+		ReauthenticationToken string `json:"reauthentication_token"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if user.TOTPEnabled {
+		app.twoFactorAlreadyEnabledResponse(w, r)
+		return
+	}
+
+	v := validator.New()
+
+	err = app.confirmIdentity(v, user, "current_password", input.CurrentPassword, input.ReauthenticationToken)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	user.TOTPSecret = secret
+
+	err = app.models.Users.UpdateUser(user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	env := envelope{
+		"secret":      secret,
+		"otpauth_uri": totp.URI(totpIssuer, user.Email, secret),
+	}
+
+	err = app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) confirmTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	var input struct {
+		Code string `json:"code"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if user.TOTPEnabled {
+		app.twoFactorAlreadyEnabledResponse(w, r)
+		return
+	}
+
+	v := validator.New()
+
+	v.Check(input.Code != "", "code", "must be provided")
+	v.Check(user.TOTPSecret != "", "code", "two-factor setup has not been started")
+	if v.Valid() {
+		valid, err := app.useTOTPCode(user, input.Code)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		v.Check(valid, "code", "invalid code")
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user.TOTPEnabled = true
+
+	err = app.models.Users.UpdateUser(user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	recoveryCodes, err := app.newRecoveryCodes(user)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"recovery_codes": recoveryCodes}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// disableTwoFactorHandler turns two-factor authentication off. Whoever did so
+// without the owner knowing must not keep the sessions it protected, so every
+// session ends and the caller gets a new one.
+func (app *application) disableTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	var input struct {
+		CurrentPassword       string `json:"current_password"`
+		ReauthenticationToken string `json:"reauthentication_token"`
+		ReturnTokens          bool   `json:"return_tokens"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if !user.TOTPEnabled && user.TOTPSecret == "" {
+		app.twoFactorNotEnabledResponse(w, r)
+		return
+	}
+
+	v := validator.New()
+
+	err = app.confirmIdentity(v, user, "current_password", input.CurrentPassword, input.ReauthenticationToken)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user.TOTPEnabled = false
+	user.TOTPSecret = ""
+
+	err = app.models.Users.UpdateUser(user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	for _, scope := range []string{data.ScopeRecovery, data.ScopeTwoFactor, data.ScopeRefresh} {
+		err = app.models.Tokens.DeleteAllForUser(scope, user.ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	tokens, err := app.startSession(w, r, user, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	env := envelope{"message": "two-factor authentication was disabled"}
+	if input.ReturnTokens {
+		env["authentication_tokens"] = tokens
+	}
+
+	err = app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// regenerateRecoveryCodesHandler replaces the user's recovery codes, for when
+// they were lost or used up.
+func (app *application) regenerateRecoveryCodesHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	var input struct {
+		CurrentPassword       string `json:"current_password"`
+		ReauthenticationToken string `json:"reauthentication_token"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if !user.TOTPEnabled {
+		app.twoFactorNotEnabledResponse(w, r)
+		return
+	}
+
+	v := validator.New()
+
+	err = app.confirmIdentity(v, user, "current_password", input.CurrentPassword, input.ReauthenticationToken)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	recoveryCodes, err := app.newRecoveryCodes(user)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"recovery_codes": recoveryCodes}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// newRecoveryCodes replaces the user's recovery codes with new ones. They are
+// stored hashed like every other token, so this is the only time their
+// plaintext is available.
+func (app *application) newRecoveryCodes(user *data.User) ([]string, error) {
+	err := app.models.Tokens.DeleteAllForUser(data.ScopeRecovery, user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	recoveryCodes := make([]string, 0, recoveryCodesNumber)
+	for i := 0; i < recoveryCodesNumber; i++ {
+		token, err := app.models.Tokens.New(user.ID, recoveryCodeTTL, data.ScopeRecovery)
+		if err != nil {
+			return nil, err
+		}
+		recoveryCodes = append(recoveryCodes, token.Plaintext)
+	}
+	return recoveryCodes, nil
+}
+
+func (app *application) loginTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		TokenPlaintext string `json:"token"`
+		Code           string `json:"code"`
+		RecoveryCode   string `json:"recovery_code"`
+		ReturnTokens   bool   `json:"return_tokens"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	data.ValidateTokenPlaintext(v, input.TokenPlaintext)
+	v.Check(input.Code != "" || input.RecoveryCode != "", "code", "code or recovery_code must be provided")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := app.models.Users.GetForToken(data.ScopeTwoFactor, input.TokenPlaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired two-factor token")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if user.IsLocked() {
+		app.invalidCredentialsResponse(w, r)
+		return
+	}
+
+	var valid bool
+	if input.Code != "" {
+		valid, err = app.useTOTPCode(user, input.Code)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	} else {
+		recoveryCode := strings.ToUpper(strings.TrimSpace(input.RecoveryCode))
+
+		err = app.models.Tokens.Consume(data.ScopeRecovery, user.ID, recoveryCode)
+		switch {
+		case err == nil:
+			valid = true
+		case !errors.Is(err, data.ErrRecordNotFound):
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	// Wrong codes count towards the same lockout as wrong passwords.
+	if !valid {
+		err = app.models.Users.RecordFailedLogin(user, app.config.lockout.threshold, app.config.lockout.base, app.config.lockout.max)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		app.invalidCredentialsResponse(w, r)
+		return
+	}
+
+	err = app.models.Tokens.DeleteAllForUser(data.ScopeTwoFactor, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if user.FailedLoginAttempts > 0 {
+		err = app.models.Users.ResetFailedLogins(user)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	app.sessionResponse(w, r, user, input.ReturnTokens)
+}
+
+// useTOTPCode checks a code from the user's authenticator app and uses it up.
+// A code is only accepted once, even within the time it's valid for.
+func (app *application) useTOTPCode(user *data.User, code string) (bool, error) {
+	step, ok := totp.Validate(user.TOTPSecret, code, time.Now())
+	if !ok {
+		return false, nil
+	}
+	return app.models.Users.UseTOTPStep(user.ID, step)
+}
@@ -2,17 +2,20 @@ package main
 
 import (
 	"errors"
+	"net"
 	"net/http"
+	"strconv"
 	"time"
 
 	"godvanced.forstes.github.com/internal/data"
+	"godvanced.forstes.github.com/internal/password"
 	"godvanced.forstes.github.com/internal/validator"
-	"golang.org/x/crypto/bcrypt"
 )
 
 func (app *application) listUserIkigaisHandler(w http.ResponseWriter, r *http.Request) {
 	var input struct {
 		SearchEmail string
+		Region      string
 		data.Filters
 	}
 
@@ -20,17 +23,21 @@ func (app *application) listUserIkigaisHandler(w http.ResponseWriter, r *http.Re
 	qs := r.URL.Query()
 
 	input.SearchEmail = app.readString(qs, "search", "")
+	input.Region = app.readString(qs, "region", "")
 	input.Filters.Page = app.readInt(qs, "page", 1, v)
 	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
 	input.Filters.Sort = app.readString(qs, "sort", "-created_at")
 	input.Filters.SortSafelist = []string{"created_at", "-created_at", "email", "-email"}
 
+	v.Check(input.Region == "" || validator.PermittedValue(input.Region, data.Regions...), "region",
+		"must be one of ikigai, passion, mission, vocation, profession, none")
+
 	if data.ValidateFilters(v, input.Filters); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
-	ikigais, metadata, err := app.models.Users.GetUserIkigais(input.SearchEmail, input.Filters)
+	ikigais, metadata, err := app.models.Users.GetUserIkigais(input.SearchEmail, input.Region, input.Filters)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -68,16 +75,24 @@ func (app *application) register(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	hashedPass, err := bcrypt.GenerateFromPassword([]byte(user.Password), 12)
+	user.Password, err = app.passwords.Hash(user.Password)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
-	user.Password = string(hashedPass)
 
 	err = app.models.Users.InsertUser(user)
 	if err != nil {
-		app.serverErrorResponse(w, r, err)
+		switch {
+		case errors.Is(err, data.ErrDuplicateEmail):
+			v.AddError("email", "a user with this email address already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrDuplicateName):
+			v.AddError("name", "a user with this name already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
 		return
 	}
 
@@ -99,13 +114,11 @@ func (app *application) register(w http.ResponseWriter, r *http.Request) {
 		}
 	})
 
-	token, err := app.generateJWT(user)
+	_, err = app.startSession(w, r, user, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
-	cookie := &http.Cookie{Name: "auth_token", Value: token, Expires: time.Now().Add(app.config.jwtOptions.expires), HttpOnly: true}
-	http.SetCookie(w, cookie)
 
 	err = app.writeJSON(w, http.StatusCreated, envelope{"message": "successfully registered"}, nil)
 	if err != nil {
@@ -115,8 +128,9 @@ func (app *application) register(w http.ResponseWriter, r *http.Request) {
 
 func (app *application) login(w http.ResponseWriter, r *http.Request) {
 	var input struct {
-		Email    string `json:"email"`
-		Password string `json:"password"`
+		Email        string `json:"email"`
+		Password     string `json:"password"`
+		ReturnTokens bool   `json:"return_tokens"`
 	}
 
 	err := app.readJSON(w, r, &input)
@@ -125,50 +139,174 @@ func (app *application) login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if app.loginLimiter.Exhausted(ip) {
+		app.rateLimitExceededResponse(w, r)
+		return
+	}
+
+	// Unknown emails, locked accounts and wrong passwords all get the same
+	// response and take about the same time, so the endpoint doesn't tell
+	// which emails are registered.
 	user, err := app.models.Users.GetUser(input.Email)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
-			app.notFoundResponse(w, r)
+			app.passwords.Compare(app.dummyPasswordHash, input.Password)
+			app.loginLimiter.Allow(ip)
+			app.invalidCredentialsResponse(w, r)
 		default:
 			app.serverErrorResponse(w, r, err)
 		}
 		return
 	}
 
-	err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(input.Password))
+	if user.IsLocked() {
+		app.passwords.Compare(app.dummyPasswordHash, input.Password)
+		app.loginLimiter.Allow(ip)
+		app.invalidCredentialsResponse(w, r)
+		return
+	}
+
+	err = app.passwords.Compare(user.Password, input.Password)
+	if err != nil {
+		switch {
+		case errors.Is(err, password.ErrMismatchedHashAndPassword):
+			app.loginLimiter.Allow(ip)
+
+			err = app.models.Users.RecordFailedLogin(user, app.config.lockout.threshold, app.config.lockout.base, app.config.lockout.max)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+
+			if user.IsLocked() {
+				app.logger.PrintInfo("account locked after failed logins", map[string]string{
+					"user_id":      strconv.FormatInt(user.ID, 10),
+					"ip":           ip,
+					"locked_until": user.LockedUntil.Format(time.RFC3339),
+				})
+			}
+			app.invalidCredentialsResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if user.FailedLoginAttempts > 0 {
+		err = app.models.Users.ResetFailedLogins(user)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	// The plaintext is only at hand during a login, so that's when hashes of
+	// an older algorithm or with older parameters get replaced.
+	if app.passwords.NeedsRehash(user.Password) {
+		err = app.rehashPassword(user, input.Password)
+		if err != nil {
+			app.logger.PrintError(err, map[string]string{"user_id": strconv.FormatInt(user.ID, 10)})
+		}
+	}
+
+	app.finishLogin(w, r, user, input.ReturnTokens)
+}
+
+func (app *application) rehashPassword(user *data.User, plaintext string) error {
+	hash, err := app.passwords.Hash(plaintext)
 	if err != nil {
-		app.badRequestResponse(w, r, data.ErrInvalidCredentials)
+		return err
+	}
+	user.Password = hash
+
+	return app.models.Users.UpdateUser(user)
+}
+
+// finishLogin is called once the first factor of a login has been checked.
+// Users with two-factor authentication get a short-lived challenge token to
+// present with their code at /v1/login/2fa, everyone else gets a session.
+func (app *application) finishLogin(w http.ResponseWriter, r *http.Request, user *data.User, returnTokens bool) {
+	if user.TOTPEnabled {
+		challenge, err := app.models.Tokens.New(user.ID, 5*time.Minute, data.ScopeTwoFactor)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		env := envelope{
+			"two_factor_required": true,
+			"two_factor_token":    challenge.Plaintext,
+			"expiry":              challenge.Expiry,
+		}
+
+		err = app.writeJSON(w, http.StatusAccepted, env, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
 	}
 
-	token, err := app.generateJWT(user)
+	app.sessionResponse(w, r, user, returnTokens)
+}
+
+func (app *application) sessionResponse(w http.ResponseWriter, r *http.Request, user *data.User, returnTokens bool) {
+	tokens, err := app.startSession(w, r, user, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
-	cookie := &http.Cookie{Name: "auth_token", Value: token, Expires: time.Now().Add(app.config.jwtOptions.expires), HttpOnly: true}
-	http.SetCookie(w, cookie)
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"message": "successfully authorized"}, nil)
+	env := envelope{"message": "successfully authorized"}
+	if returnTokens {
+		env["authentication_tokens"] = tokens
+	}
+
+	err = app.writeJSON(w, http.StatusOK, env, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
 
 func (app *application) logout(w http.ResponseWriter, r *http.Request) {
-	cookie := &http.Cookie{
-		Name:   "auth_token",
-		Value:  "",
-		MaxAge: -1,
+	refreshToken, _ := app.readRefreshToken(w, r)
+	if refreshToken != "" {
+		err := app.models.Tokens.DeleteFamilyForToken(data.ScopeRefresh, refreshToken)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
 	}
 
-	http.SetCookie(w, cookie)
+	app.clearSession(w)
 	err := app.writeJSON(w, http.StatusOK, envelope{"message": "logged out"}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
 
+func (app *application) logoutEverywhere(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	err := app.models.Tokens.DeleteAllForUser(data.ScopeRefresh, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.clearSession(w)
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "logged out of all sessions"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
 func (app *application) activateUserHandler(w http.ResponseWriter, r *http.Request) {
 	var input struct {
 		TokenPlaintext string `json:"token"`
@@ -223,3 +361,82 @@ func (app *application) activateUserHandler(w http.ResponseWriter, r *http.Reque
 		app.serverErrorResponse(w, r, err)
 	}
 }
+
+func (app *application) updateUserPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Password       string `json:"password"`
+		TokenPlaintext string `json:"token"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	data.ValidatePasswordPlaintext(v, input.Password)
+	data.ValidateTokenPlaintext(v, input.TokenPlaintext)
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := app.models.Users.GetForToken(data.ScopePasswordReset, input.TokenPlaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired password reset token")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	user.Password, err = app.passwords.Hash(input.Password)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.Users.UpdateUser(user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.models.Tokens.DeleteAllForUser(data.ScopePasswordReset, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.Tokens.DeleteAllForUser(data.ScopeRefresh, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Whoever was guessing the old password has nothing left to guess, so a
+	// locked out user can sign in with the new one right away.
+	if user.FailedLoginAttempts > 0 || user.LockedUntil != nil {
+		err = app.models.Users.ResetFailedLogins(user)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "your password was successfully reset"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -3,6 +3,8 @@ package data
 import (
 	"context"
 	"errors"
+	"fmt"
+	"sort"
 	"time"
 
 	"github.com/jackc/pgx/v5"
@@ -11,12 +13,37 @@ import (
 )
 
 type Activity struct {
-	ID           int64   `json:"id"`
-	UserID       int64   `json:"-"`
-	Name         string  `json:"name"`
-	AnswerPoints []int16 `json:"answer_points"`
-	AnswersSum   int16   `json:"answers_sum"`
-	Status       int16   `json:"status"`
+	ID              int64              `json:"id"`
+	UserID          int64              `json:"-"`
+	Name            string             `json:"name"`
+	Responses       []*Response        `json:"responses,omitempty"`
+	AnswerPoints    []int16            `json:"answer_points"`
+	AnswersSum      int16              `json:"answers_sum"`
+	Status          int16              `json:"status"`
+	DimensionScores map[string]float64 `json:"dimension_scores"`
+	Region          string             `json:"region"`
+	Scorer          string             `json:"scorer"`
+	ScorerVersion   int                `json:"scorer_version"`
+	// LegacyAnswerPoints is set on activities stored before responses were
+	// recorded. Only their answer points are known.
+	LegacyAnswerPoints bool `json:"-"`
+	// StatusOverrideID is the override that set the status, until the
+	// activity is scored again.
+	StatusOverrideID *int64 `json:"-"`
+}
+
+// Response is the answer chosen for one question of an activity. Its points,
+// the most points the question gives, its weight and dimension are copied from
+// the answers and the question when the response is submitted, so later edits
+// of either don't change how it was scored. A response outlives its question
+// and answer, whose IDs read as 0 once they were deleted.
+type Response struct {
+	QuestionID int    `json:"question_id"`
+	AnswerID   int    `json:"answer_id"`
+	Points     int16  `json:"points"`
+	MaxPoints  int16  `json:"max_points"`
+	Weight     int16  `json:"-"`
+	Dimension  string `json:"-"`
 }
 
 const (
@@ -25,12 +52,84 @@ const (
 	Trash  = 2
 )
 
+// Regions of the Ikigai diagram an activity can fall into, by which of the
+// circles it satisfies.
+const (
+	RegionIkigai     = "ikigai"
+	RegionPassion    = "passion"
+	RegionMission    = "mission"
+	RegionVocation   = "vocation"
+	RegionProfession = "profession"
+	RegionNone       = "none"
+)
+
+var Regions = []string{RegionIkigai, RegionPassion, RegionMission, RegionVocation, RegionProfession, RegionNone}
+
 func ValidateActivity(v *validator.Validator, activity *Activity) {
 	v.Check(activity.Name != "", "name", "must be provided")
 	v.Check(len(activity.Name) <= 64, "name", "must not be more than 64 bytes long")
 	v.Check(activity.AnswerPoints != nil, "answer_points", "must be provided")
 	v.Check(activity.AnswersSum >= 0, "answer_points", "must be positive value")
-	v.Check(activity.Status >= 0 && activity.Status <= 2, "status", "should be equal 0, 1, or 2")
+	ValidateStatus(v, activity.Status)
+}
+
+// ValidateResponses checks the submitted responses against the answers they
+// refer to, and fills in their points. answers must hold every answer
+// referred to that exists.
+func ValidateResponses(v *validator.Validator, responses []*Response, answers map[int]*Answer) {
+	v.Check(responses != nil, "responses", "must be provided")
+
+	questions := make(map[int]bool)
+
+	for _, response := range responses {
+		v.Check(!questions[response.QuestionID], "responses", "must not contain a question more than once")
+		questions[response.QuestionID] = true
+
+		answer, ok := answers[response.AnswerID]
+		if !ok {
+			v.AddError("responses", fmt.Sprintf("answer %d doesn't exist", response.AnswerID))
+			continue
+		}
+		v.Check(answer.QuestionId == response.QuestionID, "responses",
+			fmt.Sprintf("answer %d doesn't belong to question %d", response.AnswerID, response.QuestionID))
+
+		response.Points = answer.Points
+	}
+}
+
+// SetResponses replaces the activity's responses and derives AnswerPoints
+// from them, ordered by question.
+func (a *Activity) SetResponses(responses []*Response) {
+	sort.Slice(responses, func(i, j int) bool { return responses[i].QuestionID < responses[j].QuestionID })
+
+	a.Responses = responses
+	a.LegacyAnswerPoints = false
+	a.AnswerPoints = make([]int16, len(responses))
+	for i, response := range responses {
+		a.AnswerPoints[i] = response.Points
+	}
+}
+
+func ValidateStatus(v *validator.Validator, status int16) {
+	v.Check(status >= 0 && status <= 2, "status", "should be equal 0, 1, or 2")
+}
+
+// StatusOverride records a manual change of an activity's status, which is
+// otherwise only set by scoring its answers.
+type StatusOverride struct {
+	ID         int64     `json:"id"`
+	ActivityID int64     `json:"activity_id"`
+	UserID     *int64    `json:"user_id"`
+	OldStatus  int16     `json:"old_status"`
+	NewStatus  int16     `json:"new_status"`
+	Reason     string    `json:"reason"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+func ValidateStatusOverride(v *validator.Validator, override *StatusOverride) {
+	ValidateStatus(v, override.NewStatus)
+	v.Check(override.Reason != "", "reason", "must be provided")
+	v.Check(len(override.Reason) <= 500, "reason", "must not be more than 500 bytes long")
 }
 
 type ActivityModel struct {
@@ -43,7 +142,8 @@ func (m ActivityModel) GetActivity(id int64) (*Activity, error) {
 	}
 
 	query :=
-		`SELECT user_id, name, answer_points, answers_sum, status
+		`SELECT user_id, name, answer_points, answers_sum, status, dimension_scores, region, scorer, scorer_version,
+			legacy_answer_points, status_override_id
 		FROM activities
 		WHERE id = $1`
 
@@ -58,6 +158,12 @@ func (m ActivityModel) GetActivity(id int64) (*Activity, error) {
 		&activity.AnswerPoints,
 		&activity.AnswersSum,
 		&activity.Status,
+		&activity.DimensionScores,
+		&activity.Region,
+		&activity.Scorer,
+		&activity.ScorerVersion,
+		&activity.LegacyAnswerPoints,
+		&activity.StatusOverrideID,
 	)
 
 	if err != nil {
@@ -73,7 +179,7 @@ func (m ActivityModel) GetActivity(id int64) (*Activity, error) {
 
 func (m ActivityModel) GetActivities(userID int64, filters Filters) ([]*Activity, Metadata, error) {
 	query := `
-		SELECT count(*) OVER(), id, name, answer_points, answers_sum, status
+		SELECT count(*) OVER(), id, name, answer_points, answers_sum, status, dimension_scores, region, scorer, scorer_version
 		FROM activities
 		WHERE user_id = $1
 		ORDER BY id ASC
@@ -101,6 +207,10 @@ func (m ActivityModel) GetActivities(userID int64, filters Filters) ([]*Activity
 			&activity.AnswerPoints,
 			&activity.AnswersSum,
 			&activity.Status,
+			&activity.DimensionScores,
+			&activity.Region,
+			&activity.Scorer,
+			&activity.ScorerVersion,
 		)
 		if err != nil {
 			return nil, Metadata{}, err
@@ -116,33 +226,167 @@ func (m ActivityModel) GetActivities(userID int64, filters Filters) ([]*Activity
 	return activities, metadata, nil
 }
 
+func (m ActivityModel) GetAllForUser(userID int64) ([]*Activity, error) {
+	query := `
+		SELECT id, name, answer_points, answers_sum, status, dimension_scores, region, scorer, scorer_version
+		FROM activities
+		WHERE user_id = $1
+		ORDER BY id ASC`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.Query(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	activities := []*Activity{}
+
+	for rows.Next() {
+		activity := Activity{UserID: userID}
+
+		err := rows.Scan(
+			&activity.ID,
+			&activity.Name,
+			&activity.AnswerPoints,
+			&activity.AnswersSum,
+			&activity.Status,
+			&activity.DimensionScores,
+			&activity.Region,
+			&activity.Scorer,
+			&activity.ScorerVersion,
+		)
+		if err != nil {
+			return nil, err
+		}
+		activities = append(activities, &activity)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return activities, nil
+}
+
 func (m ActivityModel) InsertActivity(activity *Activity) error {
-	query := `INSERT INTO activities (user_id, name, answer_points, answers_sum, status) VALUES ($1, $2, $3, $4, $5) RETURNING id`
+	query := `
+		INSERT INTO activities (user_id, name, answer_points, answers_sum, status, dimension_scores, region,
+			scorer, scorer_version)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		RETURNING id`
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
 	args := []any{
 		activity.UserID, activity.Name, activity.AnswerPoints, activity.AnswersSum, activity.Status,
+		activity.DimensionScores, activity.Region, activity.Scorer, activity.ScorerVersion,
+	}
+
+	tx, err := m.DB.Begin(ctx)
+	if err != nil {
+		return err
 	}
+	defer tx.Rollback(ctx)
 
-	return m.DB.QueryRow(ctx, query, args...).Scan(&activity.ID)
+	err = tx.QueryRow(ctx, query, args...).Scan(&activity.ID)
+	if err != nil {
+		return err
+	}
+
+	err = insertResponses(ctx, tx, activity)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+func insertResponses(ctx context.Context, tx pgx.Tx, activity *Activity) error {
+	for _, response := range activity.Responses {
+		_, err := tx.Exec(ctx,
+			`INSERT INTO activity_responses (activity_id, question_id, answer_id, points, max_points, weight, dimension)
+			VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+			activity.ID, response.QuestionID, response.AnswerID, response.Points, response.MaxPoints, response.Weight,
+			response.Dimension)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
+// GetResponses returns the activity's responses with the points, weights and
+// dimensions they were scored with, including those whose question or answer
+// was deleted since.
+func (m ActivityModel) GetResponses(activityID int64) ([]*Response, error) {
+	query := `
+		SELECT COALESCE(question_id, 0), COALESCE(answer_id, 0), points, max_points, weight, dimension
+		FROM activity_responses
+		WHERE activity_id = $1
+		ORDER BY question_id ASC NULLS LAST, id ASC`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.Query(ctx, query, activityID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	responses := []*Response{}
+
+	for rows.Next() {
+		var response Response
+
+		err := rows.Scan(
+			&response.QuestionID,
+			&response.AnswerID,
+			&response.Points,
+			&response.MaxPoints,
+			&response.Weight,
+			&response.Dimension,
+		)
+		if err != nil {
+			return nil, err
+		}
+		responses = append(responses, &response)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return responses, nil
+}
+
+// UpdateActivity saves the activity. Its responses are replaced only if
+// activity.Responses is set.
 func (m ActivityModel) UpdateActivity(activity *Activity) error {
 	query :=
 		`UPDATE activities
-		SET name = $1, answer_points = $2, answers_sum = $3, status = $4
-		WHERE id = $5`
+		SET name = $1, answer_points = $2, answers_sum = $3, status = $4, dimension_scores = $5, region = $6,
+			scorer = $7, scorer_version = $8, legacy_answer_points = $9, status_override_id = $10
+		WHERE id = $11`
 
 	args := []any{
-		activity.Name, activity.AnswerPoints, activity.AnswersSum, activity.Status, activity.ID,
+		activity.Name, activity.AnswerPoints, activity.AnswersSum, activity.Status, activity.DimensionScores,
+		activity.Region, activity.Scorer, activity.ScorerVersion, activity.LegacyAnswerPoints,
+		activity.StatusOverrideID, activity.ID,
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	result, err := m.DB.Exec(ctx, query, args...)
+	tx, err := m.DB.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	result, err := tx.Exec(ctx, query, args...)
 	if err != nil {
 		return err
 	}
@@ -151,5 +395,189 @@ func (m ActivityModel) UpdateActivity(activity *Activity) error {
 	if rowsAffected == 0 {
 		return ErrRecordNotFound
 	}
+
+	if activity.Responses != nil {
+		_, err = tx.Exec(ctx, `DELETE FROM activity_responses WHERE activity_id = $1`, activity.ID)
+		if err != nil {
+			return err
+		}
+
+		err = insertResponses(ctx, tx, activity)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit(ctx)
+}
+
+// GetWithoutDimensionScores returns up to limit activities stored before
+// their dimension scores and region were computed, without their responses.
+func (m ActivityModel) GetWithoutDimensionScores(limit int) ([]*Activity, error) {
+	query := `
+		SELECT id, answer_points, legacy_answer_points
+		FROM activities
+		WHERE NOT dimensions_scored
+		ORDER BY id ASC
+		LIMIT $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.Query(ctx, query, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	activities := []*Activity{}
+
+	for rows.Next() {
+		var activity Activity
+
+		err := rows.Scan(&activity.ID, &activity.AnswerPoints, &activity.LegacyAnswerPoints)
+		if err != nil {
+			return nil, err
+		}
+		activities = append(activities, &activity)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return activities, nil
+}
+
+// SetDimensionScores saves the activity's dimension scores and region only.
+func (m ActivityModel) SetDimensionScores(activity *Activity) error {
+	query := `
+		UPDATE activities
+		SET dimension_scores = $1, region = $2, dimensions_scored = true
+		WHERE id = $3`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.Exec(ctx, query, activity.DimensionScores, activity.Region, activity.ID)
+	return err
+}
+
+// OverrideStatus sets the activity's status and records the change in the
+// same transaction, so there is no override without an audit entry.
+func (m ActivityModel) OverrideStatus(activity *Activity, override *StatusOverride) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	override.ActivityID = activity.ID
+	override.OldStatus = activity.Status
+
+	query := `
+		INSERT INTO activity_status_overrides (activity_id, user_id, old_status, new_status, reason)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, created_at`
+
+	args := []any{override.ActivityID, override.UserID, override.OldStatus, override.NewStatus, override.Reason}
+
+	err = tx.QueryRow(ctx, query, args...).Scan(&override.ID, &override.CreatedAt)
+	if err != nil {
+		return err
+	}
+
+	result, err := tx.Exec(ctx, `UPDATE activities SET status = $1, status_override_id = $2 WHERE id = $3`,
+		override.NewStatus, override.ID, activity.ID)
+	if err != nil {
+		return err
+	}
+
+	if result.RowsAffected() == 0 {
+		return ErrRecordNotFound
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return err
+	}
+
+	activity.Status = override.NewStatus
+	activity.StatusOverrideID = &override.ID
 	return nil
 }
+
+func (m ActivityModel) GetStatusOverride(id int64) (*StatusOverride, error) {
+	query := `
+		SELECT id, activity_id, user_id, old_status, new_status, reason, created_at
+		FROM activity_status_overrides
+		WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var override StatusOverride
+
+	err := m.DB.QueryRow(ctx, query, id).Scan(
+		&override.ID,
+		&override.ActivityID,
+		&override.UserID,
+		&override.OldStatus,
+		&override.NewStatus,
+		&override.Reason,
+		&override.CreatedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, pgx.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &override, nil
+}
+
+func (m ActivityModel) GetStatusOverrides(activityID int64) ([]*StatusOverride, error) {
+	query := `
+		SELECT id, activity_id, user_id, old_status, new_status, reason, created_at
+		FROM activity_status_overrides
+		WHERE activity_id = $1
+		ORDER BY id ASC`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.Query(ctx, query, activityID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	overrides := []*StatusOverride{}
+
+	for rows.Next() {
+		var override StatusOverride
+
+		err := rows.Scan(
+			&override.ID,
+			&override.ActivityID,
+			&override.UserID,
+			&override.OldStatus,
+			&override.NewStatus,
+			&override.Reason,
+			&override.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		overrides = append(overrides, &override)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return overrides, nil
+}
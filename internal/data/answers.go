@@ -21,6 +21,7 @@ type Answer struct {
 func ValidateAnswer(v *validator.Validator, answer *Answer) {
 	v.Check(answer.Title != "", "title", "must be provided")
 	v.Check(len(answer.Title) <= 500, "title", "must not be more than 500 bytes long")
+	v.Check(answer.Points >= 0, "points", "must not be negative")
 }
 
 type AnswerModel struct {
@@ -111,6 +112,45 @@ func (m AnswerModel) GetAnswer(id int64) (*Answer, error) {
 	return &answer, nil
 }
 
+// GetAnswersByID returns the answers with the given IDs, keyed by ID.
+// Unknown IDs are missing from the map.
+func (m AnswerModel) GetAnswersByID(ids []int) (map[int]*Answer, error) {
+	query := `
+		SELECT id, question_id, title, points
+		FROM answers
+		WHERE id = ANY($1)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.Query(ctx, query, ids)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	answers := make(map[int]*Answer)
+
+	for rows.Next() {
+		var answer Answer
+		err := rows.Scan(
+			&answer.ID,
+			&answer.QuestionId,
+			&answer.Title,
+			&answer.Points,
+		)
+		if err != nil {
+			return nil, err
+		}
+		answers[answer.ID] = &answer
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return answers, nil
+}
+
 func (m AnswerModel) GetAllAnswers() ([]*Answer, error) {
 	query :=
 		`SELECT id, title, points
@@ -10,22 +10,31 @@ var (
 	ErrRecordNotFound     = errors.New("record not found")
 	ErrEditConflict       = errors.New("edit conflict")
 	ErrInvalidCredentials = errors.New("wrong user credentials")
+	ErrTokenReused        = errors.New("token has already been used")
+	ErrDuplicateEmail     = errors.New("duplicate email")
+	ErrDuplicateName      = errors.New("duplicate name")
 )
 
 type Models struct {
-	Users      UserModel
-	Tokens     TokenModel
-	Activities ActivityModel
-	Questions  QuestionModel
-	Answers    AnswerModel
+	Users       UserModel
+	Tokens      TokenModel
+	Permissions PermissionModel
+	APIKeys     APIKeyModel
+	Identities  IdentityModel
+	Activities  ActivityModel
+	Questions   QuestionModel
+	Answers     AnswerModel
 }
 
 func NewModels(db *pgxpool.Pool) Models {
 	return Models{
-		Users:      UserModel{DB: db},
-		Tokens:     TokenModel{DB: db},
-		Activities: ActivityModel{DB: db},
-		Questions:  QuestionModel{DB: db},
-		Answers:    AnswerModel{DB: db},
+		Users:       UserModel{DB: db},
+		Tokens:      TokenModel{DB: db},
+		Permissions: PermissionModel{DB: db},
+		APIKeys:     APIKeyModel{DB: db},
+		Identities:  IdentityModel{DB: db},
+		Activities:  ActivityModel{DB: db},
+		Questions:   QuestionModel{DB: db},
+		Answers:     AnswerModel{DB: db},
 	}
 }
@@ -0,0 +1,105 @@
+package data
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"godvanced.forstes.github.com/internal/validator"
+)
+
+// PermissionCodes lists the rows of the permissions table.
+var PermissionCodes = []string{
+	"questions:write",
+	"ikigais:read",
+	"activities:read-all",
+	"activities:write-all",
+	"activities:override-status",
+	"users:manage",
+}
+
+type Permissions []string
+
+func (p Permissions) Include(code string) bool {
+	for i := range p {
+		if code == p[i] {
+			return true
+		}
+	}
+	return false
+}
+
+func ValidatePermissions(v *validator.Validator, codes []string) {
+	v.Check(codes != nil, "permissions", "must be provided")
+	v.Check(validator.Unique(codes), "permissions", "must not contain duplicate values")
+	for _, code := range codes {
+		v.Check(validator.PermittedValue(code, PermissionCodes...), "permissions", "contains unknown permission "+code)
+	}
+}
+
+type PermissionModel struct {
+	DB *pgxpool.Pool
+}
+
+func (m PermissionModel) GetAllForUser(userID int64) (Permissions, error) {
+	query := `
+		SELECT permissions.code
+		FROM permissions
+		INNER JOIN users_permissions ON users_permissions.permission_id = permissions.id
+		WHERE users_permissions.user_id = $1
+		ORDER BY permissions.code`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.Query(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	permissions := Permissions{}
+
+	for rows.Next() {
+		var permission string
+
+		err := rows.Scan(&permission)
+		if err != nil {
+			return nil, err
+		}
+		permissions = append(permissions, permission)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return permissions, nil
+}
+
+// SetForUser replaces all permissions of the user with codes.
+func (m PermissionModel) SetForUser(userID int64, codes []string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx, `DELETE FROM users_permissions WHERE user_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO users_permissions
+		SELECT $1, permissions.id FROM permissions WHERE permissions.code = ANY($2)`
+
+	_, err = tx.Exec(ctx, query, userID, codes)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
@@ -12,15 +12,33 @@ import (
 )
 
 type Question struct {
-	ID       int       `json:"id"`
-	Title    string    `json:"title"`
-	VideoUrl string    `json:"video_url"`
-	Answers  []*Answer `json:"answers"`
+	ID        int       `json:"id"`
+	Title     string    `json:"title"`
+	VideoUrl  string    `json:"video_url"`
+	Weight    int16     `json:"weight"`
+	Dimension string    `json:"dimension"`
+	Answers   []*Answer `json:"answers"`
+	// MaxPoints is the most points any answer of the question gives.
+	MaxPoints int16 `json:"-"`
 }
 
+// The four circles of the Ikigai model. A question tagged with one of them
+// counts towards that circle's score.
+const (
+	DimensionLove       = "love"
+	DimensionGoodAt     = "good_at"
+	DimensionWorldNeeds = "world_needs"
+	DimensionPaidFor    = "paid_for"
+)
+
+var Dimensions = []string{DimensionLove, DimensionGoodAt, DimensionWorldNeeds, DimensionPaidFor}
+
 func ValidateQuestion(v *validator.Validator, question *Question) {
 	v.Check(question.Title != "", "title", "must be provided")
 	v.Check(len(question.Title) <= 500, "title", "must not be more than 500 bytes long")
+	v.Check(question.Weight >= 1 && question.Weight <= 10, "weight", "must be between 1 and 10")
+	v.Check(question.Dimension == "" || validator.PermittedValue(question.Dimension, Dimensions...), "dimension",
+		"must be empty or one of love, good_at, world_needs, paid_for")
 }
 
 type QuestionModel struct {
@@ -29,12 +47,12 @@ type QuestionModel struct {
 
 func (m QuestionModel) InsertQuestion(question *Question) error {
 	query :=
-		`WITH qrow AS (INSERT INTO questions (title) VALUES ($1) RETURNING id)
+		`WITH qrow AS (INSERT INTO questions (title, weight, dimension) VALUES ($1, $2, $3) RETURNING id)
 		INSERT INTO answers (question_id, title, points) VALUES`
 
 	valuesStr := ""
-	args := []any{question.Title}
-	i := 2
+	args := []any{question.Title, question.Weight, question.Dimension}
+	i := 4
 	for _, ans := range question.Answers {
 		valuesStr += fmt.Sprintf(" ((SELECT * FROM qrow), $%d, $%d),", i, i+1)
 		args = append(args, ans.Title, ans.Points)
@@ -77,7 +95,7 @@ func (m QuestionModel) GetQuestion(id int64) (*Question, error) {
 	}
 
 	query := `
-		SELECT id, title, video_url
+		SELECT id, title, video_url, weight, dimension
 		FROM questions
 		WHERE id = $1`
 
@@ -90,6 +108,8 @@ func (m QuestionModel) GetQuestion(id int64) (*Question, error) {
 		&question.ID,
 		&question.Title,
 		&question.VideoUrl,
+		&question.Weight,
+		&question.Dimension,
 	)
 
 	if err != nil {
@@ -103,9 +123,52 @@ func (m QuestionModel) GetQuestion(id int64) (*Question, error) {
 	return &question, nil
 }
 
+// GetQuestionsByID returns the questions with the given IDs, without their
+// answers but with their MaxPoints, keyed by ID.
+func (m QuestionModel) GetQuestionsByID(ids []int) (map[int]*Question, error) {
+	query := `
+		SELECT id, title, video_url, weight, dimension,
+			(SELECT COALESCE(MAX(points), 0) FROM answers WHERE answers.question_id = questions.id)
+		FROM questions
+		WHERE id = ANY($1)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.Query(ctx, query, ids)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	questions := make(map[int]*Question)
+
+	for rows.Next() {
+		var question Question
+
+		err := rows.Scan(
+			&question.ID,
+			&question.Title,
+			&question.VideoUrl,
+			&question.Weight,
+			&question.Dimension,
+			&question.MaxPoints,
+		)
+		if err != nil {
+			return nil, err
+		}
+		questions[question.ID] = &question
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return questions, nil
+}
+
 func (m QuestionModel) GetAllQuestions(filters Filters) ([]*Question, Metadata, error) {
 	query :=
-		`SELECT count(*) OVER(), q.id, q.title, q.video_url, a.id, a.title, a.points 
+		`SELECT count(*) OVER(), q.id, q.title, q.video_url, q.weight, q.dimension, a.id, a.title, a.points
 		FROM questions q
 		JOIN answers a ON a.question_id = q.id
 		ORDER BY q.id ASC, a.id ASC
@@ -132,6 +195,8 @@ func (m QuestionModel) GetAllQuestions(filters Filters) ([]*Question, Metadata,
 			&question.ID,
 			&question.Title,
 			&question.VideoUrl,
+			&question.Weight,
+			&question.Dimension,
 			&answer.ID,
 			&answer.Title,
 			&answer.Points,
@@ -158,12 +223,14 @@ func (m QuestionModel) GetAllQuestions(filters Filters) ([]*Question, Metadata,
 func (m QuestionModel) UpdateQuestion(question *Question) error {
 	query := `
 		UPDATE questions
-		SET title = $1, video_url = $2
-		WHERE id = $3`
+		SET title = $1, video_url = $2, weight = $3, dimension = $4
+		WHERE id = $5`
 
 	args := []any{
 		question.Title,
 		question.VideoUrl,
+		question.Weight,
+		question.Dimension,
 		question.ID,
 	}
 
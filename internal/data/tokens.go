@@ -5,29 +5,59 @@ import (
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/base32"
+	"encoding/hex"
+	"errors"
 	"time"
 
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"godvanced.forstes.github.com/internal/validator"
 )
 
 const (
-	ScopeActivation = "activation"
+	ScopeActivation    = "activation"
+	ScopePasswordReset = "password-reset"
+	ScopeRefresh       = "refresh"
+	ScopeTwoFactor     = "2fa"
+	ScopeRecovery      = "2fa-recovery"
+	ScopeEmailChange   = "email-change"
+	ScopeLogin         = "login"
+	// ScopeReauthentication tokens prove the user signed in through the
+	// identity provider again a moment ago, in place of their password.
+	ScopeReauthentication = "reauthentication"
 )
 
 type Token struct {
-	Plaintext string
-	Hash      []byte
-	UserID    int64
-	Expiry    time.Time
-	Scope     string
+	Plaintext  string     `json:"-"`
+	Hash       []byte     `json:"-"`
+	UserID     int64      `json:"-"`
+	Expiry     time.Time  `json:"expiry"`
+	Scope      string     `json:"scope"`
+	Family     []byte     `json:"-"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	UserAgent  string     `json:"user_agent,omitempty"`
+	IP         string     `json:"ip,omitempty"`
+}
+
+// Session is a login as seen by its user: the family of refresh tokens
+// rotated from it, described by the latest one.
+type Session struct {
+	ID         string     `json:"id"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	UserAgent  string     `json:"user_agent"`
+	IP         string     `json:"ip"`
+	Expiry     time.Time  `json:"expiry"`
+	Current    bool       `json:"current"`
 }
 
 func generateToken(userID int64, ttl time.Duration, scope string) (*Token, error) {
 	token := &Token{
-		UserID: userID,
-		Expiry: time.Now().Add(ttl),
-		Scope:  scope,
+		UserID:    userID,
+		Expiry:    time.Now().Add(ttl),
+		Scope:     scope,
+		CreatedAt: time.Now(),
 	}
 
 	randomBytes := make([]byte, 16)
@@ -65,12 +95,56 @@ func (m TokenModel) New(userID int64, ttl time.Duration, scope string) (*Token,
 	return token, err
 }
 
+// NewRefresh issues a refresh token for the client with the given user agent
+// and IP. Every token rotated from the same login shares a family and its
+// creation time, so a new login should pass a nil previous token.
+func (m TokenModel) NewRefresh(userID int64, ttl time.Duration, previous *Token, userAgent, ip string) (*Token, error) {
+	token, err := generateToken(userID, ttl, ScopeRefresh)
+	if err != nil {
+		return nil, err
+	}
+
+	if previous != nil {
+		token.Family = previous.Family
+		token.CreatedAt = previous.CreatedAt
+
+		lastUsed := time.Now()
+		token.LastUsedAt = &lastUsed
+	} else {
+		token.Family = make([]byte, 16)
+
+		_, err = rand.Read(token.Family)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(userAgent) > 512 {
+		userAgent = userAgent[:512]
+	}
+	token.UserAgent = userAgent
+	token.IP = ip
+
+	err = m.Insert(token)
+	return token, err
+}
+
 func (m TokenModel) Insert(token *Token) error {
 	query := `
-		INSERT INTO tokens (hash, user_id, expiry, scope)
-		VALUES ($1, $2, $3, $4)`
+		INSERT INTO tokens (hash, user_id, expiry, scope, family, created_at, last_used_at, user_agent, ip)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`
 
-	args := []any{token.Hash, token.UserID, token.Expiry, token.Scope}
+	args := []any{
+		token.Hash,
+		token.UserID,
+		token.Expiry,
+		token.Scope,
+		token.Family,
+		token.CreatedAt,
+		token.LastUsedAt,
+		token.UserAgent,
+		token.IP,
+	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
@@ -90,3 +164,294 @@ func (m TokenModel) DeleteAllForUser(scope string, userID int64) error {
 	_, err := m.DB.Exec(ctx, query, scope, userID)
 	return err
 }
+
+// UseRefresh marks a refresh token as used and returns it, so it can be
+// exchanged for a new one of the same family. Presenting a token that was
+// already used means it has leaked: the whole family is revoked and
+// ErrTokenReused is returned.
+func (m TokenModel) UseRefresh(tokenPlaintext string) (*Token, error) {
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	query := `
+		UPDATE tokens
+		SET used_at = NOW()
+		WHERE hash = $1
+		AND scope = $2
+		AND expiry > $3
+		AND used_at IS NULL
+		RETURNING user_id, expiry, family, created_at`
+
+	token := Token{Hash: tokenHash[:], Scope: ScopeRefresh}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRow(ctx, query, tokenHash[:], ScopeRefresh, time.Now()).Scan(
+		&token.UserID,
+		&token.Expiry,
+		&token.Family,
+		&token.CreatedAt,
+	)
+	if err == nil {
+		return &token, nil
+	}
+	if !errors.Is(err, pgx.ErrNoRows) {
+		return nil, err
+	}
+
+	query = `
+		SELECT family
+		FROM tokens
+		WHERE hash = $1
+		AND scope = $2
+		AND used_at IS NOT NULL`
+
+	err = m.DB.QueryRow(ctx, query, tokenHash[:], ScopeRefresh).Scan(&token.Family)
+	if err != nil {
+		switch {
+		case errors.Is(err, pgx.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	err = m.DeleteFamily(token.Family)
+	if err != nil {
+		return nil, err
+	}
+	return nil, ErrTokenReused
+}
+
+func (m TokenModel) DeleteFamily(family []byte) error {
+	query := `
+		DELETE FROM tokens
+		WHERE family = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.Exec(ctx, query, family)
+	return err
+}
+
+func (m TokenModel) DeleteFamilyForToken(scope, tokenPlaintext string) error {
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	query := `
+		DELETE FROM tokens
+		WHERE family = (SELECT family FROM tokens WHERE hash = $1 AND scope = $2)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.Exec(ctx, query, tokenHash[:], scope)
+	return err
+}
+
+// Consume deletes a single token of the user, reporting ErrRecordNotFound if
+// it doesn't exist or has expired.
+func (m TokenModel) Consume(scope string, userID int64, tokenPlaintext string) error {
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	query := `
+		DELETE FROM tokens
+		WHERE hash = $1 AND scope = $2 AND user_id = $3 AND expiry > $4`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.Exec(ctx, query, tokenHash[:], scope, userID, time.Now())
+	if err != nil {
+		return err
+	}
+
+	if result.RowsAffected() == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// GetAllForUser returns the metadata of the user's tokens, without hashes.
+func (m TokenModel) GetAllForUser(userID int64) ([]*Token, error) {
+	query := `
+		SELECT scope, expiry, created_at, last_used_at, user_agent, ip
+		FROM tokens
+		WHERE user_id = $1
+		ORDER BY expiry ASC`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.Query(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := []*Token{}
+
+	for rows.Next() {
+		token := Token{UserID: userID}
+
+		err := rows.Scan(
+			&token.Scope,
+			&token.Expiry,
+			&token.CreatedAt,
+			&token.LastUsedAt,
+			&token.UserAgent,
+			&token.IP,
+		)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, &token)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+// GetSessionsForUser returns the user's active sessions, newest first.
+// currentTokenPlaintext is the refresh token of the caller, if known, and
+// marks the session it belongs to.
+func (m TokenModel) GetSessionsForUser(userID int64, currentTokenPlaintext string) ([]*Session, error) {
+	currentHash := sha256.Sum256([]byte(currentTokenPlaintext))
+
+	query := `
+		SELECT family, created_at, last_used_at, user_agent, ip, expiry, hash = $3
+		FROM tokens
+		WHERE user_id = $1
+		AND scope = $2
+		AND used_at IS NULL
+		AND expiry > $4
+		ORDER BY created_at DESC`
+
+	args := []any{userID, ScopeRefresh, currentHash[:], time.Now()}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []*Session{}
+
+	for rows.Next() {
+		var session Session
+		var family []byte
+
+		err := rows.Scan(
+			&family,
+			&session.CreatedAt,
+			&session.LastUsedAt,
+			&session.UserAgent,
+			&session.IP,
+			&session.Expiry,
+			&session.Current,
+		)
+		if err != nil {
+			return nil, err
+		}
+		session.ID = hex.EncodeToString(family)
+		sessions = append(sessions, &session)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return sessions, nil
+}
+
+// DeleteSession revokes all refresh tokens of one of the user's sessions.
+func (m TokenModel) DeleteSession(userID int64, sessionID string) error {
+	family, err := hex.DecodeString(sessionID)
+	if err != nil || len(family) == 0 {
+		return ErrRecordNotFound
+	}
+
+	query := `
+		DELETE FROM tokens
+		WHERE user_id = $1 AND scope = $2 AND family = $3`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.Exec(ctx, query, userID, ScopeRefresh, family)
+	if err != nil {
+		return err
+	}
+
+	if result.RowsAffected() == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// tokenReaperLockID is the Postgres advisory lock held while expired tokens
+// are deleted, so that only one instance of the API does it at a time.
+const tokenReaperLockID = 4_201_001
+
+// DeleteExpired deletes expired tokens, batchSize rows at a time so that no
+// single statement holds its locks for long. If another instance holds the
+// advisory lock nothing is deleted and acquired is false.
+func (m TokenModel) DeleteExpired(batchSize int) (deleted int64, acquired bool, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	// Advisory locks belong to a session, so the lock, the deletes and the
+	// unlock all have to go through the same connection.
+	conn, err := m.DB.Acquire(ctx)
+	if err != nil {
+		return 0, false, err
+	}
+	defer conn.Release()
+
+	err = conn.QueryRow(ctx, `SELECT pg_try_advisory_lock($1)`, tokenReaperLockID).Scan(&acquired)
+	if err != nil || !acquired {
+		return 0, false, err
+	}
+
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+
+		_, unlockErr := conn.Exec(ctx, `SELECT pg_advisory_unlock($1)`, tokenReaperLockID)
+		if unlockErr != nil {
+			// A connection that may still hold the lock must not go back to
+			// the pool.
+			conn.Conn().Close(ctx)
+			if err == nil {
+				err = unlockErr
+			}
+		}
+	}()
+
+	query := `
+		DELETE FROM tokens
+		WHERE hash IN (
+			SELECT hash FROM tokens
+			WHERE expiry < $1
+			LIMIT $2
+		)`
+
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		result, err := conn.Exec(ctx, query, time.Now(), batchSize)
+		cancel()
+		if err != nil {
+			return deleted, true, err
+		}
+
+		deleted += result.RowsAffected()
+
+		if result.RowsAffected() < int64(batchSize) {
+			return deleted, true, nil
+		}
+	}
+}
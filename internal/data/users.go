@@ -8,6 +8,7 @@ import (
 	"time"
 
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"godvanced.forstes.github.com/internal/validator"
 )
@@ -20,6 +21,51 @@ type User struct {
 	Password  string    `json:"-"`
 	CreatedAt time.Time `json:"-"`
 	Activated bool      `json:"-"`
+
+	FailedLoginAttempts int        `json:"-"`
+	LockedUntil         *time.Time `json:"-"`
+
+	TOTPSecret  string `json:"-"`
+	TOTPEnabled bool   `json:"-"`
+
+	PendingEmail string     `json:"-"`
+	DeleteAfter  *time.Time `json:"-"`
+
+	// Version is incremented on every UpdateUser, so that an update based on
+	// an outdated copy of the user fails instead of undoing another one.
+	Version int `json:"-"`
+}
+
+// UserProfile is a user as shown to themselves and to admins, with the account
+// details that User leaves out of every other response.
+type UserProfile struct {
+	ID           int64      `json:"id"`
+	Role         int        `json:"role"`
+	Email        string     `json:"email"`
+	Name         string     `json:"name"`
+	CreatedAt    time.Time  `json:"created_at"`
+	Activated    bool       `json:"activated"`
+	PendingEmail string     `json:"pending_email,omitempty"`
+	DeleteAfter  *time.Time `json:"delete_after,omitempty"`
+}
+
+func (u *User) Profile() *UserProfile {
+	return &UserProfile{
+		ID:           u.ID,
+		Role:         u.Role,
+		Email:        u.Email,
+		Name:         u.Name,
+		CreatedAt:    u.CreatedAt,
+		Activated:    u.Activated,
+		PendingEmail: u.PendingEmail,
+		DeleteAfter:  u.DeleteAfter,
+	}
+}
+
+var AnonymousUser = &User{}
+
+func (u *User) IsAnonymous() bool {
+	return u == AnonymousUser
 }
 
 type UserIkigai struct {
@@ -27,6 +73,7 @@ type UserIkigai struct {
 	Email  string `json:"email"`
 	Name   string `json:"name"`
 	Ikigai string `json:"ikigai"`
+	Region string `json:"region"`
 }
 
 const (
@@ -34,12 +81,28 @@ const (
 	AdminRole = 1
 )
 
+func ValidateRole(v *validator.Validator, role int) {
+	v.Check(validator.PermittedValue(role, UserRole, AdminRole), "role", "should be equal 0 or 1")
+}
+
+func ValidateEmail(v *validator.Validator, email string) {
+	v.Check(validator.Matches(email, validator.EmailRX), "email", "incorrect format")
+}
+
+func ValidatePasswordPlaintext(v *validator.Validator, password string) {
+	v.Check(len(password) >= 8, "password", "must contain at least 8 characters")
+	v.Check(len(password) <= 128, "password", "must not be more than 128 bytes long")
+}
+
+func ValidateName(v *validator.Validator, name string) {
+	v.Check(name != "", "name", "must be provided")
+	v.Check(len(name) <= 64, "name", "must not be more than 64 bytes long")
+}
+
 func ValidateUser(v *validator.Validator, user *User) {
-	v.Check(validator.Matches(user.Email, validator.EmailRX), "email", "incorrect format")
-	v.Check(user.Name != "", "name", "must be provided")
-	v.Check(len(user.Name) <= 64, "name", "must not be more than 64 bytes long")
-	v.Check(len(user.Password) >= 8, "password", "must contain at least 8 characters")
-	v.Check(len(user.Password) <= 32, "password", "must not exceed max length")
+	ValidateEmail(v, user.Email)
+	ValidateName(v, user.Name)
+	ValidatePasswordPlaintext(v, user.Password)
 }
 
 type UserModel struct {
@@ -47,17 +110,29 @@ type UserModel struct {
 }
 
 func (m UserModel) InsertUser(user *User) error {
-	query := `INSERT INTO users (email, name, password) VALUES ($1, $2, $3) RETURNING id, role`
+	query := `INSERT INTO users (email, name, password) VALUES ($1, $2, $3) RETURNING id, role, version`
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	return m.DB.QueryRow(ctx, query, user.Email, user.Name, user.Password).Scan(&user.ID, &user.Role)
+	err := m.DB.QueryRow(ctx, query, user.Email, user.Name, user.Password).Scan(&user.ID, &user.Role, &user.Version)
+	if err != nil {
+		switch {
+		case uniqueViolation(err, "users_email_unique"):
+			return ErrDuplicateEmail
+		case uniqueViolation(err, "users_name_unique"):
+			return ErrDuplicateName
+		default:
+			return err
+		}
+	}
+	return nil
 }
 
 func (m UserModel) GetUser(email string) (*User, error) {
 	query := `
-		SELECT id, role, email, name, password, created_at, activated
+		SELECT id, role, email, name, password, created_at, activated, failed_login_attempts, locked_until,
+			totp_secret, totp_enabled, pending_email, delete_after, version
 		FROM users
 		WHERE email = $1`
 
@@ -74,6 +149,57 @@ func (m UserModel) GetUser(email string) (*User, error) {
 		&user.Password,
 		&user.CreatedAt,
 		&user.Activated,
+		&user.FailedLoginAttempts,
+		&user.LockedUntil,
+		&user.TOTPSecret,
+		&user.TOTPEnabled,
+		&user.PendingEmail,
+		&user.DeleteAfter,
+		&user.Version,
+	)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, pgx.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &user, nil
+}
+
+func (m UserModel) GetUserByID(id int64) (*User, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+		SELECT id, role, email, name, password, created_at, activated, failed_login_attempts, locked_until,
+			totp_secret, totp_enabled, pending_email, delete_after, version
+		FROM users
+		WHERE id = $1`
+
+	var user User
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRow(ctx, query, id).Scan(
+		&user.ID,
+		&user.Role,
+		&user.Email,
+		&user.Name,
+		&user.Password,
+		&user.CreatedAt,
+		&user.Activated,
+		&user.FailedLoginAttempts,
+		&user.LockedUntil,
+		&user.TOTPSecret,
+		&user.TOTPEnabled,
+		&user.PendingEmail,
+		&user.DeleteAfter,
+		&user.Version,
 	)
 
 	if err != nil {
@@ -90,38 +216,272 @@ func (m UserModel) GetUser(email string) (*User, error) {
 func (m UserModel) UpdateUser(user *User) error {
 	query := `
 		UPDATE users
-		SET email = $1, name = $2, password = $3, activated = $4
-		WHERE id = $5`
+		SET email = $1, name = $2, password = $3, activated = $4, totp_secret = $5, totp_enabled = $6, role = $7,
+			pending_email = $8, delete_after = $9, version = version + 1
+		WHERE id = $10 AND version = $11
+		RETURNING version`
 
 	args := []any{
 		user.Email,
 		user.Name,
 		user.Password,
 		user.Activated,
+		user.TOTPSecret,
+		user.TOTPEnabled,
+		user.Role,
+		user.PendingEmail,
+		user.DeleteAfter,
 		user.ID,
+		user.Version,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRow(ctx, query, args...).Scan(&user.Version)
+	if err != nil {
+		switch {
+		case uniqueViolation(err, "users_email_unique"):
+			return ErrDuplicateEmail
+		case uniqueViolation(err, "users_name_unique"):
+			return ErrDuplicateName
+		case errors.Is(err, pgx.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
 	}
 
+	return nil
+}
+
+// Reclaim hands an account that was never activated to someone who proved
+// they own its email address some other way. Whatever the account's creator
+// set up is dropped: the password is replaced, two-factor authentication and
+// a pending email change are reset, and all its tokens and API keys are
+// deleted. It returns ErrEditConflict if the account was changed or activated
+// in the meantime.
+func (m UserModel) Reclaim(user *User, passwordHash string) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	result, err := m.DB.Exec(ctx, query, args...)
+	tx, err := m.DB.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	query := `
+		UPDATE users
+		SET activated = true, password = $1, totp_secret = '', totp_enabled = false, pending_email = '',
+			version = version + 1
+		WHERE id = $2 AND version = $3 AND NOT activated
+		RETURNING version`
+
+	var version int
+
+	err = tx.QueryRow(ctx, query, passwordHash, user.ID, user.Version).Scan(&version)
 	if err != nil {
 		switch {
-		case result.RowsAffected() == 0:
+		case errors.Is(err, pgx.ErrNoRows):
 			return ErrEditConflict
 		default:
 			return err
 		}
 	}
 
+	_, err = tx.Exec(ctx, `DELETE FROM tokens WHERE user_id = $1`, user.ID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(ctx, `DELETE FROM api_keys WHERE user_id = $1`, user.ID)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return err
+	}
+
+	user.Activated = true
+	user.Password = passwordHash
+	user.TOTPSecret = ""
+	user.TOTPEnabled = false
+	user.PendingEmail = ""
+	user.Version = version
 	return nil
 }
 
-func (m UserModel) GetUserIkigais(searchEmail string, filters Filters) ([]*UserIkigai, Metadata, error) {
+func uniqueViolation(err error, constraint string) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23505" && pgErr.ConstraintName == constraint
+}
+
+func (u *User) DeletionScheduled() bool {
+	return u.DeleteAfter != nil
+}
+
+func (u *User) IsLocked() bool {
+	return u.LockedUntil != nil && u.LockedUntil.After(time.Now())
+}
+
+// RecordFailedLogin counts a failed login attempt. From the threshold-th
+// failure in a row on, the account is locked for baseLockout, doubling with
+// every further failure up to maxLockout.
+func (m UserModel) RecordFailedLogin(user *User, threshold int, baseLockout, maxLockout time.Duration) error {
+	query := `
+		UPDATE users
+		SET failed_login_attempts = failed_login_attempts + 1,
+			locked_until = CASE
+				WHEN failed_login_attempts + 1 >= $2
+				THEN NOW() + make_interval(secs => LEAST($3::float8 * power(2, LEAST(failed_login_attempts + 1 - $2, 16)), $4::float8))
+				ELSE locked_until
+			END
+		WHERE id = $1
+		RETURNING failed_login_attempts, locked_until`
+
+	args := []any{user.ID, threshold, baseLockout.Seconds(), maxLockout.Seconds()}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRow(ctx, query, args...).Scan(&user.FailedLoginAttempts, &user.LockedUntil)
+}
+
+func (m UserModel) ResetFailedLogins(user *User) error {
+	query := `
+		UPDATE users
+		SET failed_login_attempts = 0, locked_until = NULL
+		WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.Exec(ctx, query, user.ID)
+	if err != nil {
+		return err
+	}
+
+	user.FailedLoginAttempts = 0
+	user.LockedUntil = nil
+	return nil
+}
+
+// UseTOTPStep records that a TOTP code of the given time step was accepted
+// for the user. It reports false if a code of that step or a later one was
+// accepted before, in which case the code must be rejected as replayed.
+func (m UserModel) UseTOTPStep(userID, step int64) (bool, error) {
+	query := `
+		UPDATE users
+		SET totp_last_step = $1
+		WHERE id = $2 AND totp_last_step < $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.Exec(ctx, query, step, userID)
+	if err != nil {
+		return false, err
+	}
+	return result.RowsAffected() == 1, nil
+}
+
+func (m UserModel) GetAllUsers(search string, filters Filters) ([]*User, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), id, role, email, name, created_at, activated
+		FROM users
+		WHERE (email ILIKE '%%' || $1 || '%%' OR name ILIKE '%%' || $1 || '%%' OR $1 = '')
+		ORDER BY %s %s, id ASC
+		LIMIT $2 OFFSET $3`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.Query(ctx, query, search, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	users := []*User{}
+
+	for rows.Next() {
+		var user User
+
+		err := rows.Scan(
+			&totalRecords,
+			&user.ID,
+			&user.Role,
+			&user.Email,
+			&user.Name,
+			&user.CreatedAt,
+			&user.Activated,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		users = append(users, &user)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return users, metadata, nil
+}
+
+func (m UserModel) DeleteUser(id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `DELETE FROM users WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.Exec(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected := result.RowsAffected()
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// PurgeDeleted deletes the users whose deletion grace period is over. Their
+// activities, tokens and permissions go with them through ON DELETE CASCADE.
+func (m UserModel) PurgeDeleted() (int64, error) {
+	query := `
+		DELETE FROM users
+		WHERE delete_after IS NOT NULL AND delete_after <= $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	result, err := m.DB.Exec(ctx, query, time.Now())
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+// GetUserIkigais returns every user's best activity. With a region only the
+// activities in that region of the Ikigai diagram are considered, and users
+// without one are left out.
+func (m UserModel) GetUserIkigais(searchEmail, region string, filters Filters) ([]*UserIkigai, Metadata, error) {
 	query := fmt.Sprintf(
-		`SELECT count(*) OVER(), u.id, u.email, u.name, a.name 
+		`SELECT count(*) OVER(), u.id, u.email, u.name, a.name, a.region
 		FROM users u
-		JOIN activities a ON (a.user_id = u.id AND a.answers_sum = (SELECT MAX(answers_sum) FROM activities WHERE user_id = u.id)) 
+		JOIN activities a ON (a.user_id = u.id AND (a.region = $4 OR $4 = '') AND a.answers_sum = (
+			SELECT MAX(answers_sum) FROM activities WHERE user_id = u.id AND (region = $4 OR $4 = '')))
 		WHERE (u.email ILIKE $1 OR $1 = '')
 		ORDER BY u.%s %s, u.id ASC
 		LIMIT $2 OFFSET $3`, filters.sortColumn(), filters.sortDirection())
@@ -129,7 +489,7 @@ func (m UserModel) GetUserIkigais(searchEmail string, filters Filters) ([]*UserI
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	rows, err := m.DB.Query(ctx, query, searchEmail, filters.limit(), filters.offset())
+	rows, err := m.DB.Query(ctx, query, searchEmail, filters.limit(), filters.offset(), region)
 	if err != nil {
 		return nil, Metadata{}, err
 	}
@@ -147,6 +507,7 @@ func (m UserModel) GetUserIkigais(searchEmail string, filters Filters) ([]*UserI
 			&ikigai.Email,
 			&ikigai.Name,
 			&ikigai.Ikigai,
+			&ikigai.Region,
 		)
 		if err != nil {
 			return nil, Metadata{}, err
@@ -166,7 +527,9 @@ func (m UserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, error)
 	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
 
 	query := `
-		SELECT users.id, users.role, users.email, users.name, users.password, users.created_at, users.activated
+		SELECT users.id, users.role, users.email, users.name, users.password, users.created_at, users.activated,
+			users.failed_login_attempts, users.locked_until, users.totp_secret, users.totp_enabled,
+			users.pending_email, users.delete_after, users.version
 		FROM users
 		INNER JOIN tokens
 		ON users.id = tokens.user_id
@@ -189,6 +552,59 @@ func (m UserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, error)
 		&user.Password,
 		&user.CreatedAt,
 		&user.Activated,
+		&user.FailedLoginAttempts,
+		&user.LockedUntil,
+		&user.TOTPSecret,
+		&user.TOTPEnabled,
+		&user.PendingEmail,
+		&user.DeleteAfter,
+		&user.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, pgx.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &user, nil
+}
+
+func (m UserModel) GetForIdentity(provider, subject string) (*User, error) {
+	query := `
+		SELECT users.id, users.role, users.email, users.name, users.password, users.created_at, users.activated,
+			users.failed_login_attempts, users.locked_until, users.totp_secret, users.totp_enabled,
+			users.pending_email, users.delete_after, users.version
+		FROM users
+		INNER JOIN user_identities
+		ON users.id = user_identities.user_id
+		WHERE user_identities.provider = $1
+		AND user_identities.subject = $2`
+
+	args := []any{provider, subject}
+
+	var user User
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRow(ctx, query, args...).Scan(
+		&user.ID,
+		&user.Role,
+		&user.Email,
+		&user.Name,
+		&user.Password,
+		&user.CreatedAt,
+		&user.Activated,
+		&user.FailedLoginAttempts,
+		&user.LockedUntil,
+		&user.TOTPSecret,
+		&user.TOTPEnabled,
+		&user.PendingEmail,
+		&user.DeleteAfter,
+		&user.Version,
 	)
 	if err != nil {
 		switch {
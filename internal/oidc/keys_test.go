@@ -0,0 +1,33 @@
+package oidc
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+// Made up key IDs must not make every ID token fetch the key set again.
+func TestPublicKeyRefetchIsLimited(t *testing.T) {
+	var fetches int32
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&fetches, 1)
+		w.Write([]byte(`{"keys": []}`))
+	}))
+	defer server.Close()
+
+	p := &Provider{client: server.Client(), jwksURI: server.URL}
+
+	for i := 0; i < 5; i++ {
+		_, err := p.publicKey(context.Background(), "made-up")
+		if err == nil {
+			t.Fatal("expected an unknown key to be rejected")
+		}
+	}
+
+	if n := atomic.LoadInt32(&fetches); n != 1 {
+		t.Errorf("fetched the key set %d times, want 1", n)
+	}
+}
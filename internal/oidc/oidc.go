@@ -0,0 +1,321 @@
+// Package oidc implements the parts of OpenID Connect needed to sign users in
+// with an external identity provider: discovery, the authorization code flow
+// with PKCE and verification of RS256 ID tokens.
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+var ErrInvalidIDToken = errors.New("invalid id token")
+
+type Config struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+}
+
+// Claims are the ID token claims used to find or create the local user.
+type Claims struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+	Nonce         string
+	// AuthTime is when the user last signed in at the provider, if it said.
+	AuthTime time.Time
+}
+
+type Provider struct {
+	config        Config
+	client        *http.Client
+	authEndpoint  string
+	tokenEndpoint string
+	jwksURI       string
+
+	mu        sync.Mutex
+	keys      map[string]*rsa.PublicKey
+	fetchedAt time.Time
+}
+
+// keysRefetchInterval limits how often an unknown key ID makes the key set be
+// fetched again, since anyone can send an ID token with a made up one.
+const keysRefetchInterval = time.Minute
+
+// New reads the provider's discovery document.
+func New(ctx context.Context, config Config) (*Provider, error) {
+	p := &Provider{
+		config: config,
+		client: &http.Client{Timeout: 10 * time.Second},
+		keys:   make(map[string]*rsa.PublicKey),
+	}
+
+	var discovery struct {
+		Issuer                string `json:"issuer"`
+		AuthorizationEndpoint string `json:"authorization_endpoint"`
+		TokenEndpoint         string `json:"token_endpoint"`
+		JWKSURI               string `json:"jwks_uri"`
+	}
+
+	err := p.getJSON(ctx, strings.TrimSuffix(config.Issuer, "/")+"/.well-known/openid-configuration", &discovery)
+	if err != nil {
+		return nil, fmt.Errorf("oidc discovery: %w", err)
+	}
+
+	if discovery.Issuer != config.Issuer {
+		return nil, fmt.Errorf("oidc discovery: issuer %q doesn't match %q", discovery.Issuer, config.Issuer)
+	}
+
+	p.authEndpoint = discovery.AuthorizationEndpoint
+	p.tokenEndpoint = discovery.TokenEndpoint
+	p.jwksURI = discovery.JWKSURI
+
+	return p, nil
+}
+
+func (p *Provider) Issuer() string {
+	return p.config.Issuer
+}
+
+// RandomString returns a URL safe random string, used for the state, the
+// nonce and the PKCE code verifier.
+func RandomString() (string, error) {
+	randomBytes := make([]byte, 32)
+
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(randomBytes), nil
+}
+
+// CodeChallenge derives the S256 PKCE challenge from a code verifier.
+func CodeChallenge(verifier string) string {
+	hash := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(hash[:])
+}
+
+// AuthCodeURL returns the provider URL the user is sent to for signing in.
+func (p *Provider) AuthCodeURL(state, nonce, verifier string) string {
+	return p.authCodeURL(state, nonce, verifier, nil)
+}
+
+// ReauthenticationURL is like AuthCodeURL, but asks the provider to make the
+// user sign in again even if they still have a session there. The ID token
+// then tells when that was in its auth_time claim.
+func (p *Provider) ReauthenticationURL(state, nonce, verifier string) string {
+	return p.authCodeURL(state, nonce, verifier, url.Values{"prompt": {"login"}, "max_age": {"0"}})
+}
+
+func (p *Provider) authCodeURL(state, nonce, verifier string, extra url.Values) string {
+	params := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.config.ClientID},
+		"redirect_uri":          {p.config.RedirectURL},
+		"scope":                 {"openid email profile"},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {CodeChallenge(verifier)},
+		"code_challenge_method": {"S256"},
+	}
+	for key, values := range extra {
+		params[key] = values
+	}
+
+	separator := "?"
+	if strings.Contains(p.authEndpoint, "?") {
+		separator = "&"
+	}
+	return p.authEndpoint + separator + params.Encode()
+}
+
+// Exchange trades an authorization code for tokens and returns the claims of
+// the verified ID token. Checking the nonce is left to the caller.
+func (p *Provider) Exchange(ctx context.Context, code, verifier string) (*Claims, error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.config.RedirectURL},
+		"client_id":     {p.config.ClientID},
+		"client_secret": {p.config.ClientSecret},
+		"code_verifier": {verifier},
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.tokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	res, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("oidc token exchange: unexpected status %d", res.StatusCode)
+	}
+
+	var tokens struct {
+		IDToken string `json:"id_token"`
+	}
+
+	err = json.NewDecoder(res.Body).Decode(&tokens)
+	if err != nil {
+		return nil, err
+	}
+
+	return p.verifyIDToken(ctx, tokens.IDToken)
+}
+
+func (p *Provider) verifyIDToken(ctx context.Context, idToken string) (*Claims, error) {
+	token, err := jwt.Parse(idToken, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodRSA); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		kid, _ := token.Header["kid"].(string)
+		return p.publicKey(ctx, kid)
+	})
+	if err != nil || !token.Valid {
+		return nil, ErrInvalidIDToken
+	}
+
+	mapClaims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return nil, ErrInvalidIDToken
+	}
+
+	switch {
+	case !mapClaims.VerifyIssuer(p.config.Issuer, true),
+		!mapClaims.VerifyAudience(p.config.ClientID, true),
+		!mapClaims.VerifyExpiresAt(time.Now().Unix(), true):
+		return nil, ErrInvalidIDToken
+	}
+
+	claims := &Claims{}
+	claims.Subject, _ = mapClaims["sub"].(string)
+	claims.Email, _ = mapClaims["email"].(string)
+	claims.EmailVerified, _ = mapClaims["email_verified"].(bool)
+	claims.Name, _ = mapClaims["name"].(string)
+	claims.Nonce, _ = mapClaims["nonce"].(string)
+
+	if authTime, ok := mapClaims["auth_time"].(float64); ok {
+		claims.AuthTime = time.Unix(int64(authTime), 0)
+	}
+
+	if claims.Subject == "" {
+		return nil, ErrInvalidIDToken
+	}
+	return claims, nil
+}
+
+// publicKey returns the provider key with the given ID, fetching the key set
+// again when the key is unknown, since providers rotate their keys. That's
+// done at most once per keysRefetchInterval, and without holding the lock, so
+// a slow provider doesn't hold up the keys already known.
+func (p *Provider) publicKey(ctx context.Context, kid string) (*rsa.PublicKey, error) {
+	p.mu.Lock()
+	key, ok := p.keys[kid]
+	refetch := !ok && time.Since(p.fetchedAt) >= keysRefetchInterval
+	if refetch {
+		p.fetchedAt = time.Now()
+	}
+	p.mu.Unlock()
+
+	if ok {
+		return key, nil
+	}
+	if !refetch {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+
+	keys, err := p.fetchKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	p.keys = keys
+	p.mu.Unlock()
+
+	key, ok = keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	return key, nil
+}
+
+func (p *Provider) fetchKeys(ctx context.Context) (map[string]*rsa.PublicKey, error) {
+	var jwks struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+
+	err := p.getJSON(ctx, p.jwksURI, &jwks)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]*rsa.PublicKey)
+	for _, jwk := range jwks.Keys {
+		if jwk.Kty != "RSA" {
+			continue
+		}
+
+		n, err := base64.RawURLEncoding.DecodeString(jwk.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(jwk.E)
+		if err != nil {
+			return nil, err
+		}
+
+		keys[jwk.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+	return keys, nil
+}
+
+func (p *Provider) getJSON(ctx context.Context, url string, dst any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	res, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: unexpected status %d", url, res.StatusCode)
+	}
+	return json.NewDecoder(res.Body).Decode(dst)
+}
@@ -0,0 +1,143 @@
+package oidc_test
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"godvanced.forstes.github.com/internal/oidc"
+	"godvanced.forstes.github.com/internal/oidc/oidctest"
+)
+
+const redirectURL = "https://api.example.com/v1/oidc/callback"
+
+func newProvider(t *testing.T) (*oidc.Provider, *oidctest.Server) {
+	t.Helper()
+
+	server := oidctest.NewServer("client", "secret")
+	t.Cleanup(server.Close)
+
+	provider, err := oidc.New(context.Background(), oidc.Config{
+		Issuer:       server.URL,
+		ClientID:     "client",
+		ClientSecret: "secret",
+		RedirectURL:  redirectURL,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return provider, server
+}
+
+// authorize follows the authorization URL to the fake provider and returns
+// the query of the redirect back to the client.
+func authorize(t *testing.T, provider *oidc.Provider, state, nonce, verifier string) url.Values {
+	t.Helper()
+
+	client := &http.Client{
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+
+	res, err := client.Get(provider.AuthCodeURL(state, nonce, verifier))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	if res.StatusCode != http.StatusFound {
+		t.Fatalf("authorize: got status %d, want %d", res.StatusCode, http.StatusFound)
+	}
+
+	location, err := url.Parse(res.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return location.Query()
+}
+
+func TestExchange(t *testing.T) {
+	provider, _ := newProvider(t)
+
+	qs := authorize(t, provider, "state", "nonce", "verifier")
+	if qs.Get("state") != "state" {
+		t.Errorf("got state %q, want %q", qs.Get("state"), "state")
+	}
+
+	claims, err := provider.Exchange(context.Background(), qs.Get("code"), "verifier")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if claims.Subject != "fake-subject" || claims.Email != "fake.user@example.com" || !claims.EmailVerified {
+		t.Errorf("unexpected claims %+v", claims)
+	}
+	if claims.Nonce != "nonce" {
+		t.Errorf("got nonce %q, want %q", claims.Nonce, "nonce")
+	}
+}
+
+func TestExchangeWrongVerifier(t *testing.T) {
+	provider, _ := newProvider(t)
+
+	qs := authorize(t, provider, "state", "nonce", "verifier")
+
+	_, err := provider.Exchange(context.Background(), qs.Get("code"), "another-verifier")
+	if err == nil {
+		t.Fatal("expected the exchange to fail with the wrong code_verifier")
+	}
+}
+
+func TestExchangeCodeIsSingleUse(t *testing.T) {
+	provider, _ := newProvider(t)
+
+	qs := authorize(t, provider, "state", "nonce", "verifier")
+
+	_, err := provider.Exchange(context.Background(), qs.Get("code"), "verifier")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = provider.Exchange(context.Background(), qs.Get("code"), "verifier")
+	if err == nil {
+		t.Fatal("expected the second exchange of a code to fail")
+	}
+}
+
+func TestExchangeUnverifiedEmail(t *testing.T) {
+	provider, server := newProvider(t)
+
+	server.SetUser(oidctest.User{Subject: "unverified", Email: "someone@example.com", EmailVerified: false})
+
+	qs := authorize(t, provider, "state", "nonce", "verifier")
+
+	claims, err := provider.Exchange(context.Background(), qs.Get("code"), "verifier")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.EmailVerified {
+		t.Error("got a verified email, want an unverified one")
+	}
+}
+
+func TestReauthenticationURL(t *testing.T) {
+	provider, _ := newProvider(t)
+
+	authURL, err := url.Parse(provider.ReauthenticationURL("state", "nonce", "verifier"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	qs := authURL.Query()
+	if qs.Get("prompt") != "login" || qs.Get("max_age") != "0" {
+		t.Errorf("got prompt %q and max_age %q, want login and 0", qs.Get("prompt"), qs.Get("max_age"))
+	}
+
+	claims, err := provider.Exchange(context.Background(), authorize(t, provider, "state", "nonce", "verifier").Get("code"), "verifier")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.AuthTime.IsZero() {
+		t.Error("got no auth_time")
+	}
+}
@@ -0,0 +1,60 @@
+// Package password hashes and verifies user passwords. New hashes are made
+// with the current algorithm, while hashes made with older algorithms are
+// still verified so they can be replaced on the next successful login.
+package password
+
+import (
+	"errors"
+)
+
+var (
+	ErrMismatchedHashAndPassword = errors.New("password doesn't match the hash")
+	ErrUnknownHash               = errors.New("hash was made with an unknown algorithm")
+)
+
+// Algorithm is one way of hashing passwords.
+type Algorithm interface {
+	Hash(plaintext string) (string, error)
+	// Compare returns ErrMismatchedHashAndPassword if plaintext doesn't
+	// match the hash.
+	Compare(hash, plaintext string) error
+	// Recognizes reports whether the hash was made with this algorithm.
+	Recognizes(hash string) bool
+	// Outdated reports whether a hash of this algorithm was made with other
+	// parameters than the current ones.
+	Outdated(hash string) bool
+}
+
+type Hasher struct {
+	current Algorithm
+	legacy  []Algorithm
+}
+
+// NewHasher returns a Hasher that hashes with current and verifies hashes of
+// current and all the legacy algorithms.
+func NewHasher(current Algorithm, legacy ...Algorithm) *Hasher {
+	return &Hasher{current: current, legacy: legacy}
+}
+
+func (h *Hasher) Hash(plaintext string) (string, error) {
+	return h.current.Hash(plaintext)
+}
+
+func (h *Hasher) Compare(hash, plaintext string) error {
+	if h.current.Recognizes(hash) {
+		return h.current.Compare(hash, plaintext)
+	}
+
+	for _, algorithm := range h.legacy {
+		if algorithm.Recognizes(hash) {
+			return algorithm.Compare(hash, plaintext)
+		}
+	}
+	return ErrUnknownHash
+}
+
+// NeedsRehash reports whether the hash should be replaced by a new one, as it
+// was made with a legacy algorithm or with outdated parameters.
+func (h *Hasher) NeedsRehash(hash string) bool {
+	return !h.current.Recognizes(hash) || h.current.Outdated(hash)
+}
@@ -0,0 +1,80 @@
+package scoring
+
+import "godvanced.forstes.github.com/internal/data"
+
+// DimensionShare is the share of a dimension's possible points an activity
+// needs for that circle of the Ikigai model to count as satisfied.
+const DimensionShare = 2.0 / 3
+
+// regionPairs are the regions where two circles overlap, in the order they
+// win a tie.
+var regionPairs = []struct {
+	region     string
+	dimensions [2]string
+}{
+	{data.RegionPassion, [2]string{data.DimensionLove, data.DimensionGoodAt}},
+	{data.RegionMission, [2]string{data.DimensionLove, data.DimensionWorldNeeds}},
+	{data.RegionVocation, [2]string{data.DimensionWorldNeeds, data.DimensionPaidFor}},
+	{data.RegionProfession, [2]string{data.DimensionGoodAt, data.DimensionPaidFor}},
+}
+
+// DimensionScores returns the weighted share of the possible points an
+// activity got in each dimension, between 0 and 1. Responses to untagged
+// questions are left out, and so are dimensions without any question.
+func DimensionScores(responses []Response) map[string]float64 {
+	points := make(map[string]int)
+	maxPoints := make(map[string]int)
+
+	for _, response := range responses {
+		if response.Dimension == "" {
+			continue
+		}
+		points[response.Dimension] += int(response.Points) * int(response.Weight)
+		maxPoints[response.Dimension] += int(response.MaxPoints) * int(response.Weight)
+	}
+
+	scores := make(map[string]float64)
+	for dimension, max := range maxPoints {
+		if max == 0 {
+			continue
+		}
+		scores[dimension] = float64(points[dimension]) / float64(max)
+	}
+	return scores
+}
+
+// Region places an activity in the Ikigai diagram by its dimension scores.
+// It's the ikigai when all four circles are satisfied, otherwise the overlap
+// of two satisfied circles with the higher combined score, and none if no
+// two neighbouring circles are satisfied.
+func Region(scores map[string]float64) string {
+	satisfied := func(dimension string) bool {
+		score, ok := scores[dimension]
+		// See Threshold.Score for the margin.
+		return ok && score+1e-9 >= DimensionShare
+	}
+
+	all := true
+	for _, dimension := range data.Dimensions {
+		if !satisfied(dimension) {
+			all = false
+		}
+	}
+	if all {
+		return data.RegionIkigai
+	}
+
+	region := data.RegionNone
+	best := -1.0
+
+	for _, pair := range regionPairs {
+		if !satisfied(pair.dimensions[0]) || !satisfied(pair.dimensions[1]) {
+			continue
+		}
+		if sum := scores[pair.dimensions[0]] + scores[pair.dimensions[1]]; sum > best {
+			region = pair.region
+			best = sum
+		}
+	}
+	return region
+}
@@ -0,0 +1,144 @@
+// Package scoring turns the responses of an activity into its answers sum and
+// its status. Scorers are kept in a registry by name and version, so results
+// can still be explained by the scorer that produced them after the active
+// one has changed.
+package scoring
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Response is one scored answer of an activity.
+type Response struct {
+	QuestionID int
+	Points     int16
+	MaxPoints  int16
+	Weight     int16
+	// Dimension is the circle of the Ikigai model the question belongs to,
+	// empty if it isn't tagged.
+	Dimension string
+}
+
+type Result struct {
+	AnswersSum int16 `json:"answers_sum"`
+	Status     int16 `json:"status"`
+}
+
+type Scorer interface {
+	Name() string
+	// Version changes whenever the scorer would give a different result for
+	// the same responses.
+	Version() int
+	Score(responses []Response) Result
+	// Explain scores the responses like Score and tells how the result came
+	// about.
+	Explain(responses []Response) Explanation
+}
+
+// Explanation breaks a result down for the user. Points are in the scorer's
+// terms, so they are multiplied by the question weights if it weighs them.
+type Explanation struct {
+	Result
+	Scorer    string  `json:"scorer"`
+	Version   int     `json:"scorer_version"`
+	Points    int     `json:"points"`
+	MaxPoints int     `json:"max_points"`
+	Share     float64 `json:"share"`
+	// Tiers are the statuses the scorer can give, best first, with what they
+	// take for these responses.
+	Tiers   []Tier         `json:"tiers"`
+	Answers []Contribution `json:"answers"`
+	// NextStatus is the status directly above the result, if there is one
+	// within reach, and PointsToNextStatus how many points it still takes.
+	NextStatus         *int16 `json:"next_status"`
+	PointsToNextStatus int    `json:"points_to_next_status"`
+}
+
+// Tier is one status of a scorer. Share is the share of the possible points
+// it takes, zero if it's decided some other way, and MinPoints the fewest
+// points that reach it.
+type Tier struct {
+	Status    int16   `json:"status"`
+	Share     float64 `json:"share"`
+	MinPoints int     `json:"min_points"`
+}
+
+// Contribution is what one answer added to the points of an activity.
+type Contribution struct {
+	QuestionID    int   `json:"question_id"`
+	Points        int16 `json:"points"`
+	MaxPoints     int16 `json:"max_points"`
+	Weight        int   `json:"weight"`
+	Contribution  int   `json:"contribution"`
+	MissingPoints int   `json:"missing_points"`
+}
+
+func (e *Explanation) setNext(tier Tier) {
+	status := tier.Status
+	e.NextStatus = &status
+
+	if missing := tier.MinPoints - e.Points; missing > 0 {
+		e.PointsToNextStatus = missing
+	}
+}
+
+var (
+	mu       sync.RWMutex
+	registry = make(map[string]map[int]Scorer)
+)
+
+// Register adds a scorer to the registry. It panics if a scorer with the same
+// name and version is already registered.
+func Register(scorer Scorer) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	versions, ok := registry[scorer.Name()]
+	if !ok {
+		versions = make(map[int]Scorer)
+		registry[scorer.Name()] = versions
+	}
+
+	if _, dup := versions[scorer.Version()]; dup {
+		panic(fmt.Sprintf("scoring: scorer %s v%d registered twice", scorer.Name(), scorer.Version()))
+	}
+	versions[scorer.Version()] = scorer
+}
+
+// Get returns the scorer with the given name and version.
+func Get(name string, version int) (Scorer, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	scorer, ok := registry[name][version]
+	return scorer, ok
+}
+
+// Latest returns the newest version of the named scorer.
+func Latest(name string) (Scorer, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	var latest Scorer
+	for version, scorer := range registry[name] {
+		if latest == nil || version > latest.Version() {
+			latest = scorer
+		}
+	}
+	return latest, latest != nil
+}
+
+// Names returns the names of all registered scorers.
+func Names() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,176 @@
+package scoring
+
+import (
+	"math"
+
+	"godvanced.forstes.github.com/internal/data"
+)
+
+func init() {
+	Register(Threshold{
+		ScorerName:    "classic",
+		ScorerVersion: 1,
+		StrictIkigai:  true,
+		ToolShare:     2.0 / 3,
+	})
+	Register(Threshold{
+		ScorerName:    "percentage",
+		ScorerVersion: 1,
+		IkigaiShare:   0.9,
+		ToolShare:     0.6,
+	})
+	Register(Threshold{
+		ScorerName:    "weighted",
+		ScorerVersion: 1,
+		Weighted:      true,
+		IkigaiShare:   0.9,
+		ToolShare:     2.0 / 3,
+	})
+	Register(Threshold{
+		ScorerName:    "weighted-strict",
+		ScorerVersion: 1,
+		Weighted:      true,
+		StrictIkigai:  true,
+		ToolShare:     2.0 / 3,
+	})
+}
+
+// Threshold rates an activity by the share of the possible points it got.
+// With StrictIkigai an activity is only an Ikigai if every answer got its
+// maximum points, otherwise a share of at least IkigaiShare is enough. A share
+// above ToolShare makes it a Tool, anything less is Trash.
+type Threshold struct {
+	ScorerName    string
+	ScorerVersion int
+	// Weighted multiplies the points of every answer by its question's weight.
+	Weighted     bool
+	StrictIkigai bool
+	IkigaiShare  float64
+	ToolShare    float64
+}
+
+func (t Threshold) Name() string {
+	return t.ScorerName
+}
+
+func (t Threshold) Version() int {
+	return t.ScorerVersion
+}
+
+func (t Threshold) Score(responses []Response) Result {
+	var result Result
+	for _, response := range responses {
+		result.AnswersSum += response.Points
+	}
+
+	points, maxPoints, allMax := t.total(responses)
+
+	if len(responses) == 0 || maxPoints == 0 {
+		result.Status = data.Trash
+		return result
+	}
+
+	share := float64(points) / float64(maxPoints)
+
+	switch {
+	case t.StrictIkigai && allMax, !t.StrictIkigai && share >= t.IkigaiShare:
+		result.Status = data.Ikigai
+	// The margin keeps a share right at the bound, like 6 of 9 points with
+	// a bound of 2/3, from counting as above it through a rounding error.
+	case float64(points) > t.ToolShare*float64(maxPoints)+1e-9:
+		result.Status = data.Tool
+	default:
+		result.Status = data.Trash
+	}
+	return result
+}
+
+func (t Threshold) Explain(responses []Response) Explanation {
+	points, maxPoints, _ := t.total(responses)
+
+	explanation := Explanation{
+		Result:    t.Score(responses),
+		Scorer:    t.ScorerName,
+		Version:   t.ScorerVersion,
+		Points:    points,
+		MaxPoints: maxPoints,
+		Answers:   []Contribution{},
+	}
+
+	if maxPoints > 0 {
+		explanation.Share = float64(points) / float64(maxPoints)
+	}
+
+	for _, response := range responses {
+		weight := t.weight(response)
+
+		explanation.Answers = append(explanation.Answers, Contribution{
+			QuestionID:    response.QuestionID,
+			Points:        response.Points,
+			MaxPoints:     response.MaxPoints,
+			Weight:        weight,
+			Contribution:  int(response.Points) * weight,
+			MissingPoints: int(response.MaxPoints-response.Points) * weight,
+		})
+	}
+
+	ikigai := Tier{Status: data.Ikigai, MinPoints: maxPoints}
+	if !t.StrictIkigai {
+		ikigai.Share = t.IkigaiShare
+		ikigai.MinPoints = int(math.Ceil(t.IkigaiShare*float64(maxPoints) - 1e-9))
+	}
+	tool := Tier{
+		Status:    data.Tool,
+		Share:     t.ToolShare,
+		MinPoints: int(math.Floor(t.ToolShare*float64(maxPoints)+1e-9)) + 1,
+	}
+
+	// With few points to win, the points that would make an activity a Tool
+	// can already make it an Ikigai, so there is no Tool tier to reach.
+	toolReachable := tool.MinPoints < ikigai.MinPoints
+
+	explanation.Tiers = []Tier{ikigai}
+	if toolReachable {
+		explanation.Tiers = append(explanation.Tiers, tool)
+	}
+	explanation.Tiers = append(explanation.Tiers, Tier{Status: data.Trash})
+
+	// Without any points to win there is no way up.
+	if maxPoints == 0 {
+		return explanation
+	}
+
+	switch {
+	case explanation.Status == data.Tool, explanation.Status == data.Trash && !toolReachable:
+		explanation.setNext(ikigai)
+	case explanation.Status == data.Trash:
+		explanation.setNext(tool)
+	}
+	return explanation
+}
+
+// total returns the points of the responses and the most they could have
+// got, both weighted if the scorer is, and whether every answer got its
+// maximum points.
+func (t Threshold) total(responses []Response) (points, maxPoints int, allMax bool) {
+	allMax = true
+
+	for _, response := range responses {
+		weight := t.weight(response)
+
+		points += int(response.Points) * weight
+		maxPoints += int(response.MaxPoints) * weight
+
+		if response.Points < response.MaxPoints {
+			allMax = false
+		}
+	}
+	return points, maxPoints, allMax
+}
+
+func (t Threshold) weight(response Response) int {
+	if t.Weighted {
+		return int(response.Weight)
+	}
+	return 1
+}
@@ -0,0 +1,203 @@
+package scoring
+
+import (
+	"testing"
+
+	"godvanced.forstes.github.com/internal/data"
+)
+
+func mustGet(t *testing.T, name string) Scorer {
+	t.Helper()
+
+	scorer, ok := Get(name, 1)
+	if !ok {
+		t.Fatalf("scorer %s v1 not registered", name)
+	}
+	return scorer
+}
+
+// responses builds responses worth up to 3 points each with weight 1.
+func responses(points ...int16) []Response {
+	var rs []Response
+	for i, p := range points {
+		rs = append(rs, Response{QuestionID: i + 1, Points: p, MaxPoints: 3, Weight: 1})
+	}
+	return rs
+}
+
+func TestThresholdScore(t *testing.T) {
+	tests := []struct {
+		name      string
+		scorer    string
+		responses []Response
+		want      Result
+	}{
+		{"classic all max", "classic", responses(3, 3, 3), Result{9, data.Ikigai}},
+		{"classic above two thirds", "classic", responses(3, 3, 1), Result{7, data.Tool}},
+		{"classic at two thirds", "classic", responses(3, 2, 1), Result{6, data.Trash}},
+		{"classic without answers", "classic", nil, Result{0, data.Trash}},
+		{"percentage at 0.9", "percentage", []Response{{Points: 9, MaxPoints: 10, Weight: 1}}, Result{9, data.Ikigai}},
+		{"percentage above 0.6", "percentage", responses(3, 2, 2), Result{7, data.Tool}},
+		{"percentage at 0.6", "percentage", []Response{{Points: 6, MaxPoints: 10, Weight: 1}}, Result{6, data.Trash}},
+		{
+			"weighted counts the weight",
+			"weighted",
+			[]Response{{Points: 3, MaxPoints: 3, Weight: 3}, {Points: 0, MaxPoints: 3, Weight: 1}},
+			Result{3, data.Tool},
+		},
+		{
+			"weighted-strict needs every answer at its maximum",
+			"weighted-strict",
+			[]Response{{Points: 3, MaxPoints: 3, Weight: 9}, {Points: 2, MaxPoints: 3, Weight: 1}},
+			Result{5, data.Tool},
+		},
+		{
+			"questions with other maximums",
+			"classic",
+			[]Response{{Points: 5, MaxPoints: 5, Weight: 1}, {Points: 1, MaxPoints: 1, Weight: 1}},
+			Result{6, data.Ikigai},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := mustGet(t, tt.scorer).Score(tt.responses)
+			if got != tt.want {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// oldEvaluate is EvaluateActivity as it was before scorers, when every answer
+// was worth at most 3 points.
+func oldEvaluate(answerPoints []int16) (answersSum, status int16) {
+	maxPoints := int16(len(answerPoints) * 3)
+	toolBound := maxPoints * 2 / 3
+
+	for _, ans := range answerPoints {
+		answersSum += ans
+	}
+
+	switch {
+	case answersSum == maxPoints:
+		status = data.Ikigai
+	case answersSum > toolBound:
+		status = data.Tool
+	default:
+		status = data.Trash
+	}
+	return answersSum, status
+}
+
+// The classic scorer must keep rating activities like EvaluateActivity did.
+// It's checked for every combination of up to 4 answers. No answers at all
+// used to count as an Ikigai, which was a bug, so that case is left out.
+func TestClassicMatchesOldEvaluate(t *testing.T) {
+	classic := mustGet(t, "classic")
+
+	for n := 1; n <= 4; n++ {
+		combinations := 1
+		for i := 0; i < n; i++ {
+			combinations *= 4
+		}
+
+		for c := 0; c < combinations; c++ {
+			points := make([]int16, n)
+			for i, rest := 0, c; i < n; i, rest = i+1, rest/4 {
+				points[i] = int16(rest % 4)
+			}
+
+			wantSum, wantStatus := oldEvaluate(points)
+			got := classic.Score(responses(points...))
+
+			if got.AnswersSum != wantSum || got.Status != wantStatus {
+				t.Errorf("points %v: got %+v, want {AnswersSum:%d Status:%d}", points, got, wantSum, wantStatus)
+			}
+		}
+	}
+}
+
+func TestThresholdExplain(t *testing.T) {
+	ikigai, tool := int16(data.Ikigai), int16(data.Tool)
+
+	tests := []struct {
+		name        string
+		scorer      string
+		responses   []Response
+		wantStatus  int16
+		wantTiers   []int16
+		wantNext    *int16
+		wantMissing int
+	}{
+		{"trash to tool", "classic", responses(3, 1, 2), data.Trash, []int16{ikigai, tool, data.Trash}, &tool, 1},
+		{"tool to ikigai", "classic", responses(3, 3, 1), data.Tool, []int16{ikigai, tool, data.Trash}, &ikigai, 2},
+		{"ikigai is the top", "classic", responses(3, 3), data.Ikigai, []int16{ikigai, tool, data.Trash}, nil, 0},
+		{"percentage tool to ikigai", "percentage", responses(3, 2, 1), data.Tool, []int16{ikigai, tool, data.Trash}, &ikigai, 3},
+		// With one answer worth 3 points, Tool would take 3 points, which
+		// already make an Ikigai.
+		{"unreachable tool", "classic", responses(2), data.Trash, []int16{ikigai, data.Trash}, &ikigai, 1},
+		{"no answers", "classic", nil, data.Trash, []int16{ikigai, data.Trash}, nil, 0},
+		{
+			"weighted points",
+			"weighted",
+			[]Response{{Points: 1, MaxPoints: 3, Weight: 2}, {Points: 3, MaxPoints: 3, Weight: 1}},
+			data.Trash, []int16{ikigai, tool, data.Trash}, &tool, 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := mustGet(t, tt.scorer).Explain(tt.responses)
+
+			if got.Status != tt.wantStatus {
+				t.Errorf("got status %d, want %d", got.Status, tt.wantStatus)
+			}
+
+			var tiers []int16
+			for _, tier := range got.Tiers {
+				tiers = append(tiers, tier.Status)
+			}
+			if len(tiers) != len(tt.wantTiers) {
+				t.Fatalf("got tiers %v, want %v", tiers, tt.wantTiers)
+			}
+			for i := range tiers {
+				if tiers[i] != tt.wantTiers[i] {
+					t.Fatalf("got tiers %v, want %v", tiers, tt.wantTiers)
+				}
+			}
+
+			switch {
+			case tt.wantNext == nil && got.NextStatus != nil:
+				t.Errorf("got next status %d, want none", *got.NextStatus)
+			case tt.wantNext != nil && got.NextStatus == nil:
+				t.Errorf("got no next status, want %d", *tt.wantNext)
+			case tt.wantNext != nil && *got.NextStatus != *tt.wantNext:
+				t.Errorf("got next status %d, want %d", *got.NextStatus, *tt.wantNext)
+			}
+
+			if got.PointsToNextStatus != tt.wantMissing {
+				t.Errorf("got %d points to the next status, want %d", got.PointsToNextStatus, tt.wantMissing)
+			}
+
+			// The next status must be one the activity doesn't have yet.
+			if got.NextStatus != nil {
+				result := mustGet(t, tt.scorer).Score(tt.responses)
+				if result.Status == *got.NextStatus {
+					t.Errorf("already at the next status %d", *got.NextStatus)
+				}
+			}
+		})
+	}
+}
+
+func TestExplainMatchesScore(t *testing.T) {
+	for _, name := range Names() {
+		scorer, _ := Latest(name)
+		rs := responses(3, 2, 1, 0)
+
+		if got, want := scorer.Explain(rs).Result, scorer.Score(rs); got != want {
+			t.Errorf("%s: Explain gives %+v, Score gives %+v", name, got, want)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package totp
+
+import (
+	"testing"
+	"time"
+)
+
+// The SHA1 test vectors of RFC 6238 Appendix B. They have 8 digits, the 6 we
+// use are their last 6.
+func TestGenerate(t *testing.T) {
+	key := []byte("12345678901234567890")
+
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+
+	for _, tt := range tests {
+		got := generate(key, uint64(tt.unix/period))
+		if got != tt.want {
+			t.Errorf("generate at %d = %s, want %s", tt.unix, got, tt.want)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	secret := encoding.EncodeToString([]byte("12345678901234567890"))
+	now := time.Unix(1111111111, 0)
+	step := now.Unix() / period
+
+	tests := []struct {
+		name     string
+		code     string
+		wantStep int64
+		wantOK   bool
+	}{
+		{"current step", "050471", step, true},
+		{"previous step", generate([]byte("12345678901234567890"), uint64(step-1)), step - 1, true},
+		{"next step", generate([]byte("12345678901234567890"), uint64(step+1)), step + 1, true},
+		{"outside the window", generate([]byte("12345678901234567890"), uint64(step-2)), 0, false},
+		{"wrong code", "000000", 0, false},
+		{"wrong length", "50471", 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotStep, gotOK := Validate(secret, tt.code, now)
+			if gotStep != tt.wantStep || gotOK != tt.wantOK {
+				t.Errorf("Validate = (%d, %t), want (%d, %t)", gotStep, gotOK, tt.wantStep, tt.wantOK)
+			}
+		})
+	}
+}
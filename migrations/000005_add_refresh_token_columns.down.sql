@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS tokens_family_idx;
+
+ALTER TABLE tokens
+DROP COLUMN IF EXISTS family,
+DROP COLUMN IF EXISTS used_at;
@@ -0,0 +1,5 @@
+ALTER TABLE tokens
+ADD COLUMN family bytea,
+ADD COLUMN used_at timestamp(0) with time zone;
+
+CREATE INDEX IF NOT EXISTS tokens_family_idx ON tokens (family);
@@ -0,0 +1,3 @@
+ALTER TABLE users
+ADD COLUMN failed_login_attempts integer NOT NULL DEFAULT 0,
+ADD COLUMN locked_until timestamp(0) with time zone;
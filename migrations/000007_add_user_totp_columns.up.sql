@@ -0,0 +1,3 @@
+ALTER TABLE users
+ADD COLUMN totp_secret text NOT NULL DEFAULT '',
+ADD COLUMN totp_enabled bool NOT NULL DEFAULT false;
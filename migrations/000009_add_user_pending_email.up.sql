@@ -0,0 +1,2 @@
+ALTER TABLE users
+ADD COLUMN pending_email text NOT NULL DEFAULT '';
@@ -0,0 +1,2 @@
+ALTER TABLE users
+ADD COLUMN delete_after timestamp(0) with time zone;
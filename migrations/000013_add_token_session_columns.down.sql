@@ -0,0 +1,5 @@
+ALTER TABLE tokens
+DROP COLUMN IF EXISTS created_at,
+DROP COLUMN IF EXISTS last_used_at,
+DROP COLUMN IF EXISTS user_agent,
+DROP COLUMN IF EXISTS ip;
@@ -0,0 +1,5 @@
+ALTER TABLE tokens
+ADD COLUMN created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+ADD COLUMN last_used_at timestamp(0) with time zone,
+ADD COLUMN user_agent text NOT NULL DEFAULT '',
+ADD COLUMN ip text NOT NULL DEFAULT '';
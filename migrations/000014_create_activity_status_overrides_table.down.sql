@@ -0,0 +1,3 @@
+DELETE FROM permissions WHERE code = 'activities:override-status';
+
+DROP TABLE IF EXISTS activity_status_overrides;
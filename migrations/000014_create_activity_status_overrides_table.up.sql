@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS activity_status_overrides (
+    id bigserial PRIMARY KEY,
+    activity_id bigint NOT NULL REFERENCES activities ON DELETE CASCADE,
+    user_id bigint REFERENCES users ON DELETE SET NULL,
+    old_status smallint NOT NULL,
+    new_status smallint NOT NULL,
+    reason text NOT NULL,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS activity_status_overrides_activity_id_idx ON activity_status_overrides (activity_id);
+
+INSERT INTO permissions (code)
+VALUES ('activities:override-status')
+ON CONFLICT DO NOTHING;
@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS activity_responses (
+    activity_id bigint NOT NULL REFERENCES activities ON DELETE CASCADE,
+    question_id int NOT NULL REFERENCES questions ON DELETE CASCADE,
+    answer_id int NOT NULL REFERENCES answers ON DELETE CASCADE,
+    PRIMARY KEY (activity_id, question_id)
+);
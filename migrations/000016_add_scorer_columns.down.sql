@@ -0,0 +1,6 @@
+ALTER TABLE questions
+DROP COLUMN IF EXISTS weight;
+
+ALTER TABLE activities
+DROP COLUMN IF EXISTS scorer,
+DROP COLUMN IF EXISTS scorer_version;
@@ -0,0 +1,6 @@
+ALTER TABLE activities
+ADD COLUMN scorer text NOT NULL DEFAULT 'classic',
+ADD COLUMN scorer_version integer NOT NULL DEFAULT 1;
+
+ALTER TABLE questions
+ADD COLUMN weight smallint NOT NULL DEFAULT 1;
@@ -0,0 +1,8 @@
+DROP INDEX IF EXISTS activities_region_idx;
+
+ALTER TABLE activities
+DROP COLUMN IF EXISTS dimension_scores,
+DROP COLUMN IF EXISTS region;
+
+ALTER TABLE questions
+DROP COLUMN IF EXISTS dimension;
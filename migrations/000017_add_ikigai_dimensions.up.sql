@@ -0,0 +1,8 @@
+ALTER TABLE questions
+ADD COLUMN dimension text NOT NULL DEFAULT '';
+
+ALTER TABLE activities
+ADD COLUMN dimension_scores jsonb NOT NULL DEFAULT '{}',
+ADD COLUMN region text NOT NULL DEFAULT 'none';
+
+CREATE INDEX IF NOT EXISTS activities_region_idx ON activities (region);
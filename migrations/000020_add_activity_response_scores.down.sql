@@ -0,0 +1,3 @@
+ALTER TABLE activity_responses
+DROP COLUMN IF EXISTS points,
+DROP COLUMN IF EXISTS weight;
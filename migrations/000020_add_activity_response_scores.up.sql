@@ -0,0 +1,13 @@
+ALTER TABLE activity_responses
+ADD COLUMN points smallint NOT NULL DEFAULT 0,
+ADD COLUMN weight smallint NOT NULL DEFAULT 1;
+
+UPDATE activity_responses
+SET points = answers.points
+FROM answers
+WHERE answers.id = activity_responses.answer_id;
+
+UPDATE activity_responses
+SET weight = questions.weight
+FROM questions
+WHERE questions.id = activity_responses.question_id;
@@ -0,0 +1,2 @@
+ALTER TABLE activity_responses
+DROP COLUMN IF EXISTS max_points;
@@ -0,0 +1,5 @@
+ALTER TABLE activity_responses
+ADD COLUMN max_points smallint NOT NULL DEFAULT 0;
+
+UPDATE activity_responses
+SET max_points = COALESCE((SELECT MAX(points) FROM answers WHERE answers.question_id = activity_responses.question_id), 0);
@@ -0,0 +1,17 @@
+DELETE FROM activity_responses
+WHERE question_id IS NULL OR answer_id IS NULL;
+
+DROP INDEX IF EXISTS activity_responses_activity_id_question_id_idx;
+
+ALTER TABLE activity_responses
+DROP CONSTRAINT activity_responses_question_id_fkey,
+DROP CONSTRAINT activity_responses_answer_id_fkey,
+DROP COLUMN IF EXISTS id,
+DROP COLUMN IF EXISTS dimension,
+ALTER COLUMN question_id SET NOT NULL,
+ALTER COLUMN answer_id SET NOT NULL;
+
+ALTER TABLE activity_responses
+ADD PRIMARY KEY (activity_id, question_id),
+ADD CONSTRAINT activity_responses_question_id_fkey FOREIGN KEY (question_id) REFERENCES questions ON DELETE CASCADE,
+ADD CONSTRAINT activity_responses_answer_id_fkey FOREIGN KEY (answer_id) REFERENCES answers ON DELETE CASCADE;
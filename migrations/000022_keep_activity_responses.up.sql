@@ -0,0 +1,19 @@
+ALTER TABLE activity_responses
+DROP CONSTRAINT activity_responses_pkey,
+DROP CONSTRAINT activity_responses_question_id_fkey,
+DROP CONSTRAINT activity_responses_answer_id_fkey;
+
+ALTER TABLE activity_responses
+ADD COLUMN id bigserial PRIMARY KEY,
+ADD COLUMN dimension text NOT NULL DEFAULT '',
+ALTER COLUMN question_id DROP NOT NULL,
+ALTER COLUMN answer_id DROP NOT NULL,
+ADD CONSTRAINT activity_responses_question_id_fkey FOREIGN KEY (question_id) REFERENCES questions ON DELETE SET NULL,
+ADD CONSTRAINT activity_responses_answer_id_fkey FOREIGN KEY (answer_id) REFERENCES answers ON DELETE SET NULL;
+
+CREATE UNIQUE INDEX IF NOT EXISTS activity_responses_activity_id_question_id_idx ON activity_responses (activity_id, question_id);
+
+UPDATE activity_responses
+SET dimension = questions.dimension
+FROM questions
+WHERE questions.id = activity_responses.question_id;
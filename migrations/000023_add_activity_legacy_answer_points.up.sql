@@ -0,0 +1,10 @@
+ALTER TABLE activities
+ADD COLUMN legacy_answer_points boolean NOT NULL DEFAULT false;
+
+-- Activities stored before activity_responses only have their answer points,
+-- each worth at most 3 back then.
+UPDATE activities
+SET legacy_answer_points = true
+WHERE NOT EXISTS (SELECT 1 FROM activity_responses WHERE activity_responses.activity_id = activities.id)
+AND cardinality(answer_points) > 0
+AND 3 >= ALL (answer_points);
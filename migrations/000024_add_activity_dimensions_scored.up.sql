@@ -0,0 +1,5 @@
+ALTER TABLE activities
+ADD COLUMN dimensions_scored boolean NOT NULL DEFAULT false;
+
+ALTER TABLE activities
+ALTER COLUMN dimensions_scored SET DEFAULT true;
@@ -0,0 +1,2 @@
+ALTER TABLE activities
+DROP COLUMN IF EXISTS status_override_id;
@@ -0,0 +1,12 @@
+ALTER TABLE activities
+ADD COLUMN status_override_id bigint REFERENCES activity_status_overrides ON DELETE SET NULL;
+
+UPDATE activities
+SET status_override_id = latest.id
+FROM (
+    SELECT DISTINCT ON (activity_id) id, activity_id, new_status
+    FROM activity_status_overrides
+    ORDER BY activity_id, id DESC
+) AS latest
+WHERE latest.activity_id = activities.id
+AND latest.new_status = activities.status;